@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+// scopeAll grants every scope. Access tokens issued at login carry it, and
+// routes that require it are closed to personal access tokens.
+const scopeAll = "*"
+
+const (
+	scopeChirpsRead  = "chirps:read"
+	scopeChirpsWrite = "chirps:write"
+	// scopeProfileWrite covers the public profile and relations. Changing
+	// the email or password still needs full access.
+	scopeProfileWrite = "profile:write"
+	// scopeNotificationsRead covers reading the inbox and marking it read.
+	scopeNotificationsRead = "notifications:read"
+)
+
+// knownScopes are the scopes personal access tokens and OAuth clients can
+// be granted.
+var knownScopes = []string{scopeChirpsRead, scopeChirpsWrite, scopeProfileWrite, scopeNotificationsRead}
+
+var (
+	errMissingToken   = errors.New("missing bearer token")
+	errMalformedToken = errors.New("malformed token")
+	errExpiredToken   = errors.New("token expired")
+	errInvalidToken   = errors.New("invalid token")
+	errRevokedToken   = errors.New("token revoked")
+	errSuspended      = errors.New("account suspended")
+)
+
+// principal is the authenticated caller of a request.
+type principal struct {
+	UserId      int
+	IsChirpyRed bool
+	Scopes      []string
+}
+
+func (p principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scopeAll) || slices.Contains(p.Scopes, scope)
+}
+
+type principalContextKey struct{}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, error) {
+	authHeader := r.Header.Get("Authorization")
+
+	if len(authHeader) == 0 {
+		return "", errMissingToken
+	}
+
+	token, ok := strings.CutPrefix(authHeader, "Bearer ")
+
+	if !ok || len(strings.TrimSpace(token)) == 0 {
+		return "", errMalformedToken
+	}
+
+	return strings.TrimSpace(token), nil
+}
+
+// authenticate validates the access token or personal access token of r
+// and loads its principal.
+func (cfg *apiConfig) authenticate(r *http.Request) (principal, error) {
+	token, tokenErr := bearerToken(r)
+
+	if tokenErr != nil {
+		return principal{}, tokenErr
+	}
+
+	if strings.HasPrefix(token, database.PersonalAccessTokenPrefix) {
+		return cfg.authenticatePersonalAccessToken(token)
+	}
+
+	jwtClaim, claimErr := getJWTClaim(cfg.tokens, token)
+
+	if claimErr != nil {
+		logRejectedToken(claimErr)
+	}
+
+	if errors.Is(claimErr, jwt.ErrTokenExpired) {
+		return principal{}, errExpiredToken
+	}
+
+	if errors.Is(claimErr, jwt.ErrTokenMalformed) {
+		return principal{}, errMalformedToken
+	}
+
+	if errors.Is(claimErr, errRevokedToken) {
+		return principal{}, errRevokedToken
+	}
+
+	if claimErr != nil {
+		return principal{}, errInvalidToken
+	}
+
+	userIdStr, subjectErr := jwtClaim.GetSubject()
+
+	if subjectErr != nil {
+		return principal{}, errInvalidToken
+	}
+
+	userId, atoiErr := strconv.Atoi(userIdStr)
+
+	if atoiErr != nil {
+		return principal{}, errInvalidToken
+	}
+
+	user, userErr := cfg.db.GetUser(userId)
+
+	if userErr != nil {
+		return principal{}, errInvalidToken
+	}
+
+	if user.Suspended {
+		return principal{}, errSuspended
+	}
+
+	scopes := []string{scopeAll}
+	if len(jwtClaim.Scope) > 0 {
+		scopes = strings.Fields(jwtClaim.Scope)
+	}
+
+	return principal{UserId: user.Id, IsChirpyRed: user.IsChirpyRed, Scopes: scopes}, nil
+}
+
+func (cfg *apiConfig) authenticatePersonalAccessToken(token string) (principal, error) {
+	pat, patErr := cfg.db.UsePersonalAccessToken(token)
+
+	if patErr != nil {
+		return principal{}, errInvalidToken
+	}
+
+	user, userErr := cfg.db.GetUser(pat.UserId)
+
+	if userErr != nil {
+		return principal{}, errInvalidToken
+	}
+
+	if user.Suspended {
+		return principal{}, errSuspended
+	}
+
+	return principal{UserId: user.Id, IsChirpyRed: user.IsChirpyRed, Scopes: pat.Scopes}, nil
+}
+
+// middlewareAuth rejects requests without a valid access token or without
+// scope, and stores the caller's principal in the request context.
+func (cfg *apiConfig) middlewareAuth(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, authErr := cfg.authenticate(r)
+
+		if authErr != nil {
+			respondUnauthorized(w, authErr)
+			return
+		}
+
+		if !p.HasScope(scope) {
+			respondInsufficientScope(w, scope)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p)))
+	})
+}
+
+// middlewareOptionalAuth is middlewareAuth for routes that anonymous
+// callers can use too. Requests without an Authorization header pass
+// through without a principal.
+func (cfg *apiConfig) middlewareOptionalAuth(scope string, next http.Handler) http.Handler {
+	authenticated := cfg.middlewareAuth(scope, next)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.Header.Get("Authorization")) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		authenticated.ServeHTTP(w, r)
+	})
+}
+
+// peekUserId returns the caller's user id without checking scopes or
+// logging, for middleware that only uses it as a key.
+func (cfg *apiConfig) peekUserId(r *http.Request) (int, error) {
+	token, tokenErr := bearerToken(r)
+
+	if tokenErr != nil {
+		return 0, tokenErr
+	}
+
+	if strings.HasPrefix(token, database.PersonalAccessTokenPrefix) {
+		pat, patErr := cfg.db.LookupPersonalAccessToken(token)
+		return pat.UserId, patErr
+	}
+
+	jwtClaim, claimErr := getJWTClaim(cfg.tokens, token)
+
+	if claimErr != nil {
+		return 0, claimErr
+	}
+
+	return strconv.Atoi(jwtClaim.Subject)
+}
+
+// principalFromRequest returns the principal stored by middlewareAuth.
+func principalFromRequest(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalContextKey{}).(principal)
+	return p, ok
+}
+
+// respondUnauthorized sends 401 with a WWW-Authenticate challenge as
+// described in RFC 6750. A request without credentials gets no error code.
+func respondUnauthorized(w http.ResponseWriter, authErr error) {
+	challenge := `Bearer realm="chirpy"`
+
+	if !errors.Is(authErr, errMissingToken) {
+		challenge += fmt.Sprintf(`, error="invalid_token", error_description=%q`, authErr.Error())
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+}
+
+// respondInsufficientScope sends 403 naming the scope the route needs.
+func respondInsufficientScope(w http.ResponseWriter, scope string) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="chirpy", error="insufficient_scope", scope=%q`, scope))
+	respondWithError(w, http.StatusForbidden, "Insufficient scope")
+}
+
+// handlerJWKS publishes the public signing keys so other services can
+// verify access tokens without calling Chirpy.
+func (cfg *apiConfig) handlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	respondWithJson(w, http.StatusOK, cfg.tokens.keys.JWKS())
+}
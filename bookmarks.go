@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type getBookmarksResponse struct {
+	Chirps []database.Chirp `json:"chirps"`
+	Total  int              `json:"total"`
+	Offset int              `json:"offset"`
+	Limit  int              `json:"limit"`
+}
+
+func (cfg *apiConfig) handlerBookmarksCreate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirpID, atoiErr := strconv.Atoi(r.PathValue("chirpID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	bookmark, createErr := cfg.db.CreateBookmark(userId, chirpID)
+
+	if createErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, bookmark)
+}
+
+func (cfg *apiConfig) handlerBookmarksDelete(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirpID, atoiErr := strconv.Atoi(r.PathValue("chirpID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	deleteErr := cfg.db.DeleteBookmark(userId, chirpID)
+
+	if deleteErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerBookmarksList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	offset, limit, paginationErr := getPagination(r)
+
+	if paginationErr != nil {
+		respondWithError(w, http.StatusBadRequest, paginationErr.Error())
+		return
+	}
+
+	chirps, total, err := cfg.db.GetBookmarkedChirps(userId, offset, limit)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, getBookmarksResponse{chirps, total, offset, limit})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+func TestBookmarksLeaveOutTrash(t *testing.T) {
+	cfg := newTestConfig(t)
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /api/bookmarks/{chirpID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerBookmarksCreate)))
+	mux.Handle("GET /api/bookmarks", cfg.middlewareAuth(scopeChirpsRead, http.HandlerFunc(cfg.handlerBookmarksList)))
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	author, _ := cfg.db.CreateUser("author@chirpy.com", "1234")
+	reader, _ := cfg.db.CreateUser("reader@chirpy.com", "1234")
+	kept, _ := cfg.db.CreateChirp("kept", author.Id, database.ChirpOptions{})
+	trashed, _ := cfg.db.CreateChirp("trashed", author.Id, database.ChirpOptions{})
+	token, _ := getJWTString(cfg.tokens, strconv.Itoa(reader.Id))
+
+	do := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, _ := http.DefaultClient.Do(req)
+		return res
+	}
+
+	for _, chirp := range []database.Chirp{kept, trashed} {
+		if res := do("POST", "/api/bookmarks/"+strconv.Itoa(chirp.Id)); res.StatusCode != http.StatusCreated {
+			t.Fatalf("Expected bookmark to be created, got %v", res.StatusCode)
+		}
+	}
+
+	if deleteErr := cfg.db.DeleteChirp(trashed.Id); deleteErr != nil {
+		t.Fatalf("Error deleting chirp: %v", deleteErr)
+	}
+
+	res := do("GET", "/api/bookmarks")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Expected bookmarks, got %v", res.StatusCode)
+	}
+
+	bookmarks := getBookmarksResponse{}
+	json.NewDecoder(res.Body).Decode(&bookmarks)
+
+	if bookmarks.Total != 1 || len(bookmarks.Chirps) != 1 || bookmarks.Chirps[0].Id != kept.Id {
+		t.Errorf("Expected only chirp %d, got %+v", kept.Id, bookmarks)
+	}
+}
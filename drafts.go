@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type draftRequest struct {
+	Body     string `json:"body"`
+	MediaIds []int  `json:"media_ids"`
+}
+
+type publishDraftRequest struct {
+	Visibility string `json:"visibility"`
+}
+
+func (cfg *apiConfig) handlerDraftsCreate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	reqObj := draftRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !cfg.checkChirpLength(w, userId, reqObj.Body) {
+		return
+	}
+
+	draft, createErr := cfg.db.CreateDraft(reqObj.Body, userId, reqObj.MediaIds)
+
+	if errors.Is(createErr, database.ErrInvalidChirp) {
+		respondWithError(w, http.StatusBadRequest, createErr.Error())
+		return
+	}
+
+	if createErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, draft)
+}
+
+func (cfg *apiConfig) handlerDraftsList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	drafts, err := cfg.db.GetDrafts(userId)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	sort.Slice(drafts, func(i, j int) bool {
+		return drafts[i].UpdatedAt.After(drafts[j].UpdatedAt)
+	})
+
+	respondWithJson(w, http.StatusOK, drafts)
+}
+
+func (cfg *apiConfig) handlerDraftsGet(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	draftID, atoiErr := strconv.Atoi(r.PathValue("draftID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	draft, getErr := cfg.db.GetDraft(draftID, userId)
+
+	if getErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, draft)
+}
+
+func (cfg *apiConfig) handlerDraftsUpdate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	draftID, atoiErr := strconv.Atoi(r.PathValue("draftID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	reqObj := draftRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if !cfg.checkChirpLength(w, userId, reqObj.Body) {
+		return
+	}
+
+	draft, updateErr := cfg.db.UpdateDraft(draftID, userId, reqObj.Body, reqObj.MediaIds)
+
+	if errors.Is(updateErr, database.ErrInvalidChirp) {
+		respondWithError(w, http.StatusBadRequest, updateErr.Error())
+		return
+	}
+
+	if updateErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, draft)
+}
+
+func (cfg *apiConfig) handlerDraftsDelete(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	draftID, atoiErr := strconv.Atoi(r.PathValue("draftID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	deleteErr := cfg.db.DeleteDraft(draftID, userId)
+
+	if deleteErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handlerDraftsPublish(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	draftID, atoiErr := strconv.Atoi(r.PathValue("draftID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid draft ID")
+		return
+	}
+
+	reqObj := publishDraftRequest{}
+
+	if r.ContentLength != 0 {
+		decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+		if decodeErr != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	draft, getErr := cfg.db.GetDraft(draftID, userId)
+
+	if getErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	// The author's tier may have changed since the draft was saved.
+	if !cfg.checkChirpLength(w, userId, draft.Body) {
+		return
+	}
+
+	cleanedBody, censored := cfg.profanityFilter.Clean(draft.Body)
+
+	newChirp, publishErr := cfg.db.PublishDraft(draftID, userId, draft.UpdatedAt, cleanedBody, database.ChirpOptions{
+		Censored:   censored,
+		Visibility: reqObj.Visibility,
+	})
+
+	if errors.Is(publishErr, database.ErrInvalidChirp) {
+		respondWithError(w, http.StatusBadRequest, publishErr.Error())
+		return
+	}
+
+	if errors.Is(publishErr, database.ErrDraftChanged) {
+		respondWithError(w, http.StatusConflict, publishErr.Error())
+		return
+	}
+
+	if errors.Is(publishErr, database.ErrSuspended) {
+		respondWithError(w, http.StatusForbidden, "Account suspended")
+		return
+	}
+
+	if publishErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, newChirp)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handlerFollow(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	followee, getErr := cfg.db.GetUserByUsername(r.PathValue("username"))
+
+	if getErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	follow, followErr := cfg.db.FollowUser(userId, followee.Id)
+
+	if errors.Is(followErr, database.ErrBlocked) {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return
+	}
+
+	if followErr != nil {
+		respondWithError(w, http.StatusBadRequest, followErr.Error())
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, follow)
+}
+
+func (cfg *apiConfig) handlerUnfollow(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	followee, getErr := cfg.db.GetUserByUsername(r.PathValue("username"))
+
+	if getErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	unfollowErr := cfg.db.UnfollowUser(userId, followee.Id)
+
+	if unfollowErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,9 +2,8 @@ module github.com/walrus811/chirpy
 
 go 1.22.3
 
-require golang.org/x/crypto v0.23.0
-
 require (
-	github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
+	github.com/golang-jwt/jwt/v5 v5.2.1
+	github.com/joho/godotenv v1.5.1
+	golang.org/x/crypto v0.23.0
 )
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+const (
+	idempotencyTTL     = 24 * time.Hour
+	maxIdempotencyBody = 1 << 20
+)
+
+// responseRecorder copies everything written to the response so it can be
+// stored after the handler returns.
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(statusCode int) {
+	if rec.statusCode == 0 {
+		rec.statusCode = statusCode
+	}
+	rec.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (rec *responseRecorder) Write(data []byte) (int, error) {
+	if rec.statusCode == 0 {
+		rec.statusCode = http.StatusOK
+	}
+	rec.body.Write(data)
+	return rec.ResponseWriter.Write(data)
+}
+
+// idempotencyOutcome lets handlers behind middlewareIdempotency say how
+// their response is stored.
+type idempotencyOutcome struct {
+	// sessionId is the session a login created. It is stored instead of
+	// the response, which carries tokens.
+	sessionId int
+	// skip keeps responses with other credentials from being stored.
+	skip bool
+}
+
+type idempotencyOutcomeContextKey struct{}
+
+// recordIdempotentSession tells middlewareIdempotency that r signed the
+// caller in on a session, so retries get new tokens for it.
+func recordIdempotentSession(r *http.Request, sessionId int) {
+	if outcome, ok := r.Context().Value(idempotencyOutcomeContextKey{}).(*idempotencyOutcome); ok {
+		outcome.sessionId = sessionId
+	}
+}
+
+// skipIdempotentResponse tells middlewareIdempotency not to store the
+// response to r. Retries run the handler again.
+func skipIdempotentResponse(r *http.Request) {
+	if outcome, ok := r.Context().Value(idempotencyOutcomeContextKey{}).(*idempotencyOutcome); ok {
+		outcome.skip = true
+	}
+}
+
+// middlewareIdempotency honours the Idempotency-Key header. The first
+// response for a caller, route and key is stored for 24 hours and replayed
+// for retries with the same body. Reusing a key with a different body gets
+// 422. Server errors are not stored, so those requests can be retried.
+//
+// Stored responses are kept in plaintext, so handlers sending credentials
+// must call recordIdempotentSession or skipIdempotentResponse. Request
+// bodies are only fingerprinted with an HMAC, so a body holding a password
+// can't be guessed offline from the database.
+func (cfg *apiConfig) middlewareIdempotency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idempotencyKey := r.Header.Get("Idempotency-Key")
+
+		if len(idempotencyKey) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, readErr := io.ReadAll(io.LimitReader(r.Body, maxIdempotencyBody))
+
+		if readErr != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		// Unauthenticated routes such as login share the anonymous scope.
+		userId, _ := cfg.peekUserId(r)
+		key := fmt.Sprintf("%d:%s %s:%s", userId, r.Method, r.URL.Path, idempotencyKey)
+
+		mac := hmac.New(sha256.New, cfg.idempotencySecret)
+		mac.Write(body)
+		requestHash := hex.EncodeToString(mac.Sum(nil))
+
+		record, replay, beginErr := cfg.db.BeginIdempotentRequest(key, requestHash, idempotencyTTL)
+
+		if errors.Is(beginErr, database.ErrIdempotencyMismatch) {
+			respondWithError(w, http.StatusUnprocessableEntity, beginErr.Error())
+			return
+		}
+
+		if errors.Is(beginErr, database.ErrIdempotencyInProgress) {
+			respondWithError(w, http.StatusConflict, beginErr.Error())
+			return
+		}
+
+		if beginErr != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+
+		if replay && record.SessionId != 0 {
+			cfg.respondWithReissuedLogin(w, record.SessionId)
+			return
+		}
+
+		if replay {
+			w.Header().Set("Content-Type", record.ContentType)
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.Header().Set("Content-Length", strconv.Itoa(len(record.Body)))
+			w.WriteHeader(record.StatusCode)
+			w.Write(record.Body)
+			return
+		}
+
+		outcome := &idempotencyOutcome{}
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), idempotencyOutcomeContextKey{}, outcome)))
+
+		if rec.statusCode == 0 || rec.statusCode >= 500 || outcome.skip {
+			cfg.db.AbortIdempotentRequest(key)
+			return
+		}
+
+		if outcome.sessionId != 0 {
+			if completeErr := cfg.db.CompleteIdempotentLogin(key, rec.statusCode, outcome.sessionId); completeErr != nil {
+				fmt.Println("Error storing idempotent login:", completeErr)
+			}
+			return
+		}
+
+		contentType := w.Header().Get("Content-Type")
+		if len(contentType) == 0 {
+			contentType = "application/json;charset=UTF-8"
+		}
+
+		completeErr := cfg.db.CompleteIdempotentRequest(key, rec.statusCode, contentType, rec.body.Bytes())
+
+		if completeErr != nil {
+			fmt.Println("Error storing idempotent response:", completeErr)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package chirptext
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// URLWeight is the number of characters any link counts as, however long it
+// actually is.
+const URLWeight = 23
+
+var urlPattern = regexp.MustCompile(`(?i)\bhttps?://[^\s<>"]+`)
+
+// Length returns the weighted length of a chirp body: user-perceived
+// characters, with every URL counted as URLWeight.
+func Length(text string) int {
+	length := 0
+	last := 0
+
+	for _, match := range urlPattern.FindAllStringIndex(text, -1) {
+		end := match[1]
+		// Punctuation closing a sentence is not part of the link.
+		for end > match[0] && strings.ContainsRune(".,!?;:)'", rune(text[end-1])) {
+			end--
+		}
+
+		length += Graphemes(text[last:match[0]]) + URLWeight
+		last = end
+	}
+
+	return length + Graphemes(text[last:])
+}
+
+// Graphemes counts the user-perceived characters in text. It follows the
+// main rules of Unicode extended grapheme clusters: combining marks,
+// variation selectors, emoji modifiers and ZWJ sequences attach to the
+// character before them, regional indicators pair into flags, conjoining
+// Hangul jamo form syllables and CR LF counts once.
+func Graphemes(text string) int {
+	count := 0
+	var prev rune
+	joinNext := false
+	regionalRun := 0
+
+	for i, r := range text {
+		extends := false
+
+		switch {
+		case i == 0:
+		case joinNext:
+			extends = true
+		case prev == '\r' && r == '\n':
+			extends = true
+		case isExtend(r):
+			extends = true
+		case isRegionalIndicator(r) && isRegionalIndicator(prev) && regionalRun%2 == 1:
+			extends = true
+		case isHangulContinuation(prev, r):
+			extends = true
+		}
+
+		if !extends {
+			count++
+		}
+
+		if isRegionalIndicator(r) {
+			regionalRun++
+		} else {
+			regionalRun = 0
+		}
+
+		joinNext = r == '\u200d' && i > 0
+		prev = r
+	}
+
+	return count
+}
+
+func isExtend(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r == '\u200d' || r == '\u200c':
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f:
+		return true
+	case r >= 0xe0100 && r <= 0xe01ef:
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff:
+		return true
+	case r >= 0xe0020 && r <= 0xe007f:
+		return true
+	}
+
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+func isHangulLeading(r rune) bool {
+	return (r >= 0x1100 && r <= 0x115f) || (r >= 0xa960 && r <= 0xa97f)
+}
+
+func isHangulVowel(r rune) bool {
+	return (r >= 0x1160 && r <= 0x11a7) || (r >= 0xd7b0 && r <= 0xd7c6)
+}
+
+func isHangulTrailing(r rune) bool {
+	return (r >= 0x11a8 && r <= 0x11ff) || (r >= 0xd7cb && r <= 0xd7fb)
+}
+
+func isHangulSyllable(r rune) bool {
+	return r >= 0xac00 && r <= 0xd7a3
+}
+
+// isHangulContinuation reports whether r continues the Hangul syllable that
+// prev belongs to.
+func isHangulContinuation(prev, r rune) bool {
+	switch {
+	case isHangulLeading(prev):
+		return isHangulLeading(r) || isHangulVowel(r) || isHangulSyllable(r)
+	case isHangulVowel(prev):
+		return isHangulVowel(r) || isHangulTrailing(r)
+	case isHangulTrailing(prev):
+		return isHangulTrailing(r)
+	case isHangulSyllable(prev):
+		// Syllables with a final consonant (LVT) take only trailing jamo.
+		if (prev-0xac00)%28 != 0 {
+			return isHangulTrailing(r)
+		}
+		return isHangulVowel(r) || isHangulTrailing(r)
+	}
+
+	return false
+}
@@ -0,0 +1,47 @@
+package chirptext
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGraphemes(t *testing.T) {
+	testData := []struct {
+		text     string
+		expected int
+	}{
+		{text: "hello", expected: 5},
+		{text: "안녕하세요", expected: 5},
+		{text: "각", expected: 1},
+		{text: "é", expected: 1},
+		{text: "👍🏽", expected: 1},
+		{text: "👨‍👩‍👧‍👦", expected: 1},
+		{text: "🇰🇷🇺🇸", expected: 2},
+		{text: "❤️", expected: 1},
+		{text: "a\r\nb", expected: 3},
+		{text: strings.Repeat("😀", 50), expected: 50},
+	}
+
+	for _, data := range testData {
+		if count := Graphemes(data.text); count != data.expected {
+			t.Errorf("Expected %d for %q, got %d", data.expected, data.text, count)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	testData := []struct {
+		text     string
+		expected int
+	}{
+		{text: "see https://example.com/a/very/long/path?with=query", expected: 4 + URLWeight},
+		{text: "http://a.io and https://b.io!", expected: URLWeight + 5 + URLWeight + 1},
+		{text: "no links", expected: 8},
+	}
+
+	for _, data := range testData {
+		if length := Length(data.text); length != data.expected {
+			t.Errorf("Expected %d for %q, got %d", data.expected, data.text, length)
+		}
+	}
+}
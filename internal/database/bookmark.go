@@ -0,0 +1,106 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+type Bookmark struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	ChirpId   int       `json:"chirp_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateBookmark saves a chirp the user can see. Bookmarking the same chirp
+// twice is a no-op.
+func (db *DB) CreateBookmark(userId, chirpId int) (Bookmark, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, ok := db.dbStructure.Chirps[chirpId]
+
+	if !ok || !db.canView(chirp, userId) || chirp.Pending {
+		return Bookmark{}, fmt.Errorf("there's no chirp of %d", chirpId)
+	}
+
+	for _, bookmark := range db.dbStructure.Bookmarks {
+		if bookmark.UserId == userId && bookmark.ChirpId == chirpId {
+			return bookmark, nil
+		}
+	}
+
+	newBookmark := Bookmark{
+		Id:        nextId(db.dbStructure.Bookmarks),
+		UserId:    userId,
+		ChirpId:   chirpId,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	db.dbStructure.Bookmarks[newBookmark.Id] = newBookmark
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Bookmark{}, err
+	}
+
+	return newBookmark, nil
+}
+
+func (db *DB) DeleteBookmark(userId, chirpId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for id, bookmark := range db.dbStructure.Bookmarks {
+		if bookmark.UserId == userId && bookmark.ChirpId == chirpId {
+			delete(db.dbStructure.Bookmarks, id)
+			return db.writeDB(db.dbStructure)
+		}
+	}
+
+	return fmt.Errorf("bookmark not found")
+}
+
+// GetBookmarkedChirps returns one page of the chirps a user bookmarked, most
+// recently bookmarked first, with the total count. Chirps the user can no
+// longer see are left out.
+func (db *DB) GetBookmarkedChirps(userId, offset, limit int) ([]Chirp, int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	bookmarks := make([]Bookmark, 0)
+
+	for _, bookmark := range db.dbStructure.Bookmarks {
+		if bookmark.UserId != userId {
+			continue
+		}
+
+		chirp, ok := db.dbStructure.Chirps[bookmark.ChirpId]
+		if ok && db.canView(chirp, userId) {
+			bookmarks = append(bookmarks, bookmark)
+		}
+	}
+
+	sort.Slice(bookmarks, func(i, j int) bool {
+		return bookmarks[i].Id > bookmarks[j].Id
+	})
+
+	chirps := make([]Chirp, 0)
+
+	for _, bookmark := range paginate(bookmarks, offset, limit) {
+		chirps = append(chirps, db.viewChirp(db.dbStructure.Chirps[bookmark.ChirpId], userId))
+	}
+
+	return chirps, len(bookmarks), nil
+}
+
+// deleteBookmarks drops every bookmark of a chirp without persisting.
+func (db *DB) deleteBookmarks(chirpId int) {
+	for id, bookmark := range db.dbStructure.Bookmarks {
+		if bookmark.ChirpId == chirpId {
+			delete(db.dbStructure.Bookmarks, id)
+		}
+	}
+}
@@ -1,45 +1,218 @@
 package database
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
 
 type Chirp struct {
 	Id       int    `json:"id"`
 	Body     string `json:"body"`
 	AuthorId int    `json:"author_id"`
+	Mentions []int  `json:"mentions,omitempty"`
+	MediaIds []int  `json:"media_ids,omitempty"`
+	Censored bool   `json:"censored"`
+	// Pending chirps are scheduled for PublishAt and only visible to their
+	// author until then.
+	Pending    bool       `json:"pending"`
+	PublishAt  *time.Time `json:"publish_at,omitempty"`
+	Visibility string     `json:"visibility"`
+	Poll       *Poll      `json:"poll,omitempty"`
+	// Hidden chirps were taken down by a moderator and are only visible to
+	// their author.
+	Hidden bool `json:"hidden,omitempty"`
+	// DeletedAt marks a chirp in the trash. It is hidden from every read until
+	// it is restored or purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
-func (db *DB) CreateChirp(body string, authorId int) (Chirp, error) {
-	_, getUserErr := db.GetUser(authorId)
+// ChirpOptions holds the optional parts of a new chirp.
+type ChirpOptions struct {
+	MediaIds []int
+	// Censored records that the body was cleaned by the profanity filter.
+	Censored bool
+	// PublishAt schedules the chirp instead of publishing it right away.
+	PublishAt *time.Time
+	// Visibility defaults to VisibilityPublic.
+	Visibility string
+	// PollOptions and PollClosesAt attach a poll when options are given.
+	PollOptions  []string
+	PollClosesAt time.Time
+}
+
+const (
+	VisibilityPublic    = "public"
+	VisibilityFollowers = "followers"
+	VisibilityPrivate   = "private"
+)
+
+var (
+	ErrInvalidChirp   = errors.New("invalid chirp")
+	ErrRestoreExpired = errors.New("restore period has expired")
+)
+
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@])@(\w{1,30})`)
+
+// resolveMentions returns the ids of the users whose handles are mentioned in
+// body, in order of first appearance. Unknown handles and users blocked by or
+// blocking the author are ignored.
+func (db *DB) resolveMentions(body string, authorId int) []int {
+	userIds := make([]int, 0)
+	seen := map[int]bool{}
+
+	for _, match := range mentionPattern.FindAllStringSubmatch(body, -1) {
+		user, getErr := db.getUserByUsername(match[1])
+
+		if getErr != nil || seen[user.Id] || db.isBlocked(authorId, user.Id) {
+			continue
+		}
+
+		seen[user.Id] = true
+		userIds = append(userIds, user.Id)
+	}
+
+	return userIds
+}
+
+// notifyMentions notifies the users mentioned in chirp who are allowed to
+// read it. Blocks are checked again since a scheduled chirp may be published
+// long after its mentions were resolved.
+func (db *DB) notifyMentions(chirp Chirp) error {
+	for _, userId := range chirp.Mentions {
+		if userId == chirp.AuthorId || !db.canView(chirp, userId) {
+			continue
+		}
+
+		_, notifyErr := db.addNotification(userId, chirp.AuthorId, NotificationMention, chirp.Id)
+
+		if notifyErr != nil {
+			return notifyErr
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) CreateChirp(body string, authorId int, options ChirpOptions) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	newChirp, addErr := db.addChirp(body, authorId, options)
+
+	if addErr != nil {
+		return Chirp{}, addErr
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return db.viewChirp(newChirp, authorId), nil
+}
+
+// addChirp stores a new chirp in memory without persisting it, so callers
+// can batch it with related changes.
+func (db *DB) addChirp(body string, authorId int, options ChirpOptions) (Chirp, error) {
+	author, getUserErr := db.getUser(authorId)
 
 	if getUserErr != nil {
 		return Chirp{}, fmt.Errorf("user not found")
 	}
 
+	if author.Suspended {
+		return Chirp{}, ErrSuspended
+	}
+
+	mediaErr := db.validateChirpMedia(authorId, options.MediaIds)
+
+	if mediaErr != nil {
+		return Chirp{}, mediaErr
+	}
+
+	if options.PublishAt != nil && !options.PublishAt.After(time.Now()) {
+		return Chirp{}, fmt.Errorf("%w: publish_at must be in the future", ErrInvalidChirp)
+	}
+
+	switch options.Visibility {
+	case "":
+		options.Visibility = VisibilityPublic
+	case VisibilityPublic, VisibilityFollowers, VisibilityPrivate:
+	default:
+		return Chirp{}, fmt.Errorf("%w: unknown visibility %s", ErrInvalidChirp, options.Visibility)
+	}
+
+	var poll *Poll
+
+	if len(options.PollOptions) > 0 {
+		publishAt := time.Now()
+		if options.PublishAt != nil {
+			publishAt = *options.PublishAt
+		}
+
+		newPoll, pollErr := newPoll(options.PollOptions, options.PollClosesAt, publishAt)
+
+		if pollErr != nil {
+			return Chirp{}, pollErr
+		}
+
+		poll = newPoll
+	}
+
+	id := max(nextId(db.dbStructure.Chirps), db.dbStructure.LastChirpId+1)
+	db.dbStructure.LastChirpId = id
+
 	newChirp := Chirp{
-		Id:       len(db.dbStructure.Chirps) + 1,
-		Body:     body,
-		AuthorId: authorId,
+		Id:         id,
+		Body:       body,
+		AuthorId:   authorId,
+		Mentions:   db.resolveMentions(body, authorId),
+		MediaIds:   options.MediaIds,
+		Censored:   options.Censored,
+		Pending:    options.PublishAt != nil,
+		PublishAt:  options.PublishAt,
+		Visibility: options.Visibility,
+		Poll:       poll,
 	}
 
 	db.dbStructure.Chirps[newChirp.Id] = newChirp
 
-	err := db.writeDB(db.dbStructure)
+	if !newChirp.Pending {
+		notifyErr := db.notifyMentions(newChirp)
 
-	if err != nil {
-		return Chirp{}, err
+		if notifyErr != nil {
+			return Chirp{}, notifyErr
+		}
 	}
 
 	return newChirp, nil
 }
 
+// DeleteChirp moves a chirp to the trash, unpins it and removes its
+// bookmarks. The chirp can be restored with RestoreChirp, without its
+// bookmarks, until PurgeDeletedChirps removes it for good.
 func (db *DB) DeleteChirp(id int) error {
-	_, ok := db.dbStructure.Chirps[id]
+	db.mux.Lock()
+	defer db.mux.Unlock()
 
-	if !ok {
+	chirp, ok := db.dbStructure.Chirps[id]
+
+	if !ok || chirp.DeletedAt != nil {
 		return fmt.Errorf("chirp not found")
 	}
 
-	delete(db.dbStructure.Chirps, id)
+	deletedAt := time.Now().UTC()
+	chirp.DeletedAt = &deletedAt
+	db.dbStructure.Chirps[id] = chirp
+	db.deleteBookmarks(id)
+
+	if author, ok := db.dbStructure.Users[chirp.AuthorId]; ok && author.PinnedChirpId == id {
+		author.PinnedChirpId = 0
+		db.dbStructure.Users[author.Id] = author
+	}
 
 	err := db.writeDB(db.dbStructure)
 
@@ -50,35 +223,277 @@ func (db *DB) DeleteChirp(id int) error {
 	return nil
 }
 
-func (db *DB) GetChirps() ([]Chirp, error) {
+// deleteChirp permanently removes a chirp along with its bookmarks, votes
+// and notifications and the author's pin on it, without persisting the
+// change. Reports on the chirp are kept for the moderation record.
+func (db *DB) deleteChirp(id int) error {
+	chirp, ok := db.dbStructure.Chirps[id]
+
+	if !ok {
+		return fmt.Errorf("chirp not found")
+	}
+
+	delete(db.dbStructure.Chirps, id)
+	db.deleteBookmarks(id)
+
+	for voteId, vote := range db.dbStructure.Votes {
+		if vote.ChirpId == id {
+			delete(db.dbStructure.Votes, voteId)
+		}
+	}
+
+	for notificationId, notification := range db.dbStructure.Notifications {
+		if notification.ChirpId == id {
+			delete(db.dbStructure.Notifications, notificationId)
+		}
+	}
+
+	if author, ok := db.dbStructure.Users[chirp.AuthorId]; ok && author.PinnedChirpId == id {
+		author.PinnedChirpId = 0
+		db.dbStructure.Users[author.Id] = author
+	}
+
+	return nil
+}
+
+// canView reports whether viewerId may see chirp. A viewerId of 0 stands for
+// an anonymous caller. Every read path filters through it.
+func (db *DB) canView(chirp Chirp, viewerId int) bool {
+	if chirp.DeletedAt != nil {
+		return false
+	}
+
+	if chirp.AuthorId == viewerId {
+		return true
+	}
+
+	if chirp.Pending || chirp.Hidden || db.isBlocked(chirp.AuthorId, viewerId) {
+		return false
+	}
+
+	if author, ok := db.dbStructure.Users[chirp.AuthorId]; ok && author.Suspended {
+		return false
+	}
+
+	switch chirp.Visibility {
+	case VisibilityPrivate:
+		return false
+	case VisibilityFollowers:
+		_, following := db.getFollow(viewerId, chirp.AuthorId)
+		return viewerId != 0 && following
+	}
+
+	return true
+}
+
+// inListing reports whether chirp belongs in the timelines and listings
+// shown to viewerId: it must be visible and not from a muted author.
+func (db *DB) inListing(chirp Chirp, viewerId int) bool {
+	return db.canView(chirp, viewerId) && !db.isMuted(viewerId, chirp.AuthorId)
+}
+
+// viewChirp returns chirp as viewerId sees it, with per-viewer parts such as
+// poll results filled in.
+func (db *DB) viewChirp(chirp Chirp, viewerId int) Chirp {
+	if chirp.Poll != nil {
+		chirp.Poll = db.viewPoll(chirp.Id, *chirp.Poll, viewerId)
+	}
+
+	return chirp
+}
+
+func (db *DB) GetChirps(viewerId int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
 	chirps := make([]Chirp, 0)
 
 	for _, chirp := range db.dbStructure.Chirps {
-		chirps = append(chirps, chirp)
+		if db.inListing(chirp, viewerId) {
+			chirps = append(chirps, db.viewChirp(chirp, viewerId))
+		}
 	}
 
 	return chirps, nil
 }
 
-func (db *DB) GetChirpsByAuthorId(authorId int) ([]Chirp, error) {
+func (db *DB) GetChirpsByAuthorId(authorId, viewerId int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
 	chirps := make([]Chirp, 0)
 
 	for _, chirp := range db.dbStructure.Chirps {
-		if chirp.AuthorId == authorId {
-			chirps = append(chirps, chirp)
+		if chirp.AuthorId == authorId && db.inListing(chirp, viewerId) {
+			chirps = append(chirps, db.viewChirp(chirp, viewerId))
 		}
 	}
 
 	return chirps, nil
 }
 
-func (db *DB) GetChirp(id int) (Chirp, error) {
+func (db *DB) GetChirp(id, viewerId int) (Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
 
 	chirp, ok := db.dbStructure.Chirps[id]
 
-	if !ok {
+	if !ok || !db.canView(chirp, viewerId) {
 		return Chirp{}, fmt.Errorf("there's no chirp of %d", id)
 	}
 
-	return chirp, nil
+	return db.viewChirp(chirp, viewerId), nil
+}
+
+// GetScheduledChirps returns the author's chirps that are still pending.
+func (db *DB) GetScheduledChirps(authorId int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	chirps := make([]Chirp, 0)
+
+	for _, chirp := range db.dbStructure.Chirps {
+		if chirp.AuthorId == authorId && chirp.Pending && chirp.DeletedAt == nil {
+			chirps = append(chirps, db.viewChirp(chirp, authorId))
+		}
+	}
+
+	return chirps, nil
+}
+
+// CancelScheduledChirp deletes a pending chirp of authorId before it is
+// published.
+func (db *DB) CancelScheduledChirp(id, authorId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, ok := db.dbStructure.Chirps[id]
+
+	if !ok || chirp.AuthorId != authorId || !chirp.Pending || chirp.DeletedAt != nil {
+		return fmt.Errorf("there's no scheduled chirp of %d", id)
+	}
+
+	delete(db.dbStructure.Chirps, id)
+
+	return db.writeDB(db.dbStructure)
+}
+
+// PublishDueChirps publishes every pending chirp whose PublishAt is not after
+// now, including ones that fell due while the server was down.
+func (db *DB) PublishDueChirps(now time.Time) ([]Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	published := make([]Chirp, 0)
+
+	for id, chirp := range db.dbStructure.Chirps {
+		// A pending chirp without a publish time can't fall due. Skip it
+		// rather than take the scheduler down. Chirps in the trash wait for
+		// a restore.
+		if !chirp.Pending || chirp.PublishAt == nil || chirp.PublishAt.After(now) || chirp.DeletedAt != nil {
+			continue
+		}
+
+		chirp.Pending = false
+		db.dbStructure.Chirps[id] = chirp
+
+		notifyErr := db.notifyMentions(chirp)
+
+		if notifyErr != nil {
+			return nil, notifyErr
+		}
+
+		published = append(published, chirp)
+	}
+
+	if len(published) == 0 {
+		return published, nil
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return published, nil
+}
+
+// GetDeletedChirps returns the author's chirps in the trash.
+func (db *DB) GetDeletedChirps(authorId int) ([]Chirp, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	chirps := make([]Chirp, 0)
+
+	for _, chirp := range db.dbStructure.Chirps {
+		if chirp.AuthorId == authorId && chirp.DeletedAt != nil {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	return chirps, nil
+}
+
+// RestoreChirp takes a chirp of authorId out of the trash if it was deleted
+// less than grace ago.
+func (db *DB) RestoreChirp(id, authorId int, grace time.Duration) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, ok := db.dbStructure.Chirps[id]
+
+	if !ok || chirp.AuthorId != authorId || chirp.DeletedAt == nil {
+		return Chirp{}, fmt.Errorf("there's no deleted chirp of %d", id)
+	}
+
+	if time.Since(*chirp.DeletedAt) > grace {
+		return Chirp{}, ErrRestoreExpired
+	}
+
+	chirp.DeletedAt = nil
+	db.dbStructure.Chirps[id] = chirp
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return db.viewChirp(chirp, authorId), nil
+}
+
+// PurgeDeletedChirps permanently removes chirps deleted before cutoff and
+// returns how many were removed.
+func (db *DB) PurgeDeletedChirps(cutoff time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	purged := 0
+
+	for id, chirp := range db.dbStructure.Chirps {
+		if chirp.DeletedAt == nil || chirp.DeletedAt.After(cutoff) {
+			continue
+		}
+
+		deleteErr := db.deleteChirp(id)
+
+		if deleteErr != nil {
+			return purged, deleteErr
+		}
+
+		purged++
+	}
+
+	if purged == 0 {
+		return 0, nil
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
 }
@@ -4,18 +4,45 @@ import (
 	"encoding/json"
 	"os"
 	"sync"
+	"time"
 )
 
 type DBStructure struct {
-	Chirps        map[int]Chirp  `json:"chirps"`
-	Users         map[int]User   `json:"users"`
-	RefreshTokens map[int]string `json:"refreshTokens"`
+	Chirps        map[int]Chirp        `json:"chirps"`
+	Users         map[int]User         `json:"users"`
+	Sessions      map[int]Session      `json:"sessions"`
+	Notifications map[int]Notification `json:"notifications"`
+	Media         map[int]Media        `json:"media"`
+	Drafts        map[int]Draft        `json:"drafts"`
+	Follows       map[int]Follow       `json:"follows"`
+	Votes         map[int]Vote         `json:"votes"`
+	Bookmarks     map[int]Bookmark     `json:"bookmarks"`
+	Relations     map[int]Relation     `json:"relations"`
+	Reports       map[int]Report       `json:"reports"`
+	// ModerationActions is the audit log of admin actions on reports.
+	ModerationActions map[int]ModerationAction `json:"moderationActions"`
+	// IdempotencyKeys is keyed by caller, route and Idempotency-Key header.
+	IdempotencyKeys map[string]IdempotencyRecord `json:"idempotencyKeys"`
+	// RevokedAccessTokens maps the jti of a revoked access token to its
+	// expiry.
+	RevokedAccessTokens  map[string]time.Time        `json:"revokedAccessTokens"`
+	PersonalAccessTokens map[int]PersonalAccessToken `json:"personalAccessTokens"`
+	OAuthClients         map[int]OAuthClient         `json:"oauthClients"`
+	OAuthCodes           map[int]OAuthCode           `json:"oauthCodes"`
+	// LastChirpId is the highest chirp id ever assigned. Purged chirps
+	// leave their ids unused, so reports and moderation actions never
+	// point at a different chirp.
+	LastChirpId int `json:"lastChirpId,omitempty"`
+	// RefreshTokens holds the one token per user written by older versions.
+	// It is converted to Sessions on load.
+	RefreshTokens map[int]string `json:"refreshTokens,omitempty"`
 }
 
 type DB struct {
 	path        string
 	mux         *sync.RWMutex
 	dbStructure DBStructure
+	usernames   map[string]int
 }
 
 func (db *DB) ensureDB() error {
@@ -26,7 +53,7 @@ func (db *DB) ensureDB() error {
 			return err
 		}
 
-		_, err = file.WriteString(`{"chirps":{}, "users":{}, "refreshTokens":{}}`)
+		_, err = file.WriteString(`{"chirps":{}, "users":{}, "sessions":{}, "notifications":{}, "media":{}, "drafts":{}, "follows":{}, "votes":{}, "bookmarks":{}, "relations":{}, "reports":{}, "moderationActions":{}, "idempotencyKeys":{}, "revokedAccessTokens":{}, "personalAccessTokens":{}, "oauthClients":{}, "oauthCodes":{}}`)
 		if err != nil {
 			return err
 		}
@@ -50,11 +77,81 @@ func (db *DB) loadDB() (DBStructure, error) {
 		return DBStructure{}, err
 	}
 
+	structure.ensureMaps()
+
 	return structure, nil
 }
 
+// ensureMaps initialises collections missing from database files written by
+// older versions, so writes into them don't panic.
+func (structure *DBStructure) ensureMaps() {
+	if structure.Chirps == nil {
+		structure.Chirps = map[int]Chirp{}
+	}
+	if structure.Users == nil {
+		structure.Users = map[int]User{}
+	}
+	if structure.Sessions == nil {
+		structure.Sessions = map[int]Session{}
+	}
+	if structure.Notifications == nil {
+		structure.Notifications = map[int]Notification{}
+	}
+	if structure.Media == nil {
+		structure.Media = map[int]Media{}
+	}
+	if structure.Drafts == nil {
+		structure.Drafts = map[int]Draft{}
+	}
+	if structure.Follows == nil {
+		structure.Follows = map[int]Follow{}
+	}
+	if structure.Votes == nil {
+		structure.Votes = map[int]Vote{}
+	}
+	if structure.Bookmarks == nil {
+		structure.Bookmarks = map[int]Bookmark{}
+	}
+	if structure.Relations == nil {
+		structure.Relations = map[int]Relation{}
+	}
+	if structure.Reports == nil {
+		structure.Reports = map[int]Report{}
+	}
+	if structure.ModerationActions == nil {
+		structure.ModerationActions = map[int]ModerationAction{}
+	}
+	if structure.IdempotencyKeys == nil {
+		structure.IdempotencyKeys = map[string]IdempotencyRecord{}
+	}
+	if structure.RevokedAccessTokens == nil {
+		structure.RevokedAccessTokens = map[string]time.Time{}
+	}
+	if structure.PersonalAccessTokens == nil {
+		structure.PersonalAccessTokens = map[int]PersonalAccessToken{}
+	}
+	if structure.OAuthClients == nil {
+		structure.OAuthClients = map[int]OAuthClient{}
+	}
+	if structure.OAuthCodes == nil {
+		structure.OAuthCodes = map[int]OAuthCode{}
+	}
+}
+
+// nextId returns an id one above the largest key in use, so ids stay unique
+// even after entries have been deleted.
+func nextId[T any](entries map[int]T) int {
+	maxId := 0
+	for id := range entries {
+		if id > maxId {
+			maxId = id
+		}
+	}
+	return maxId + 1
+}
+
 func (db *DB) writeDB(structure DBStructure) error {
-	file, err := os.OpenFile(db.path, os.O_RDWR, 0644)
+	file, err := os.OpenFile(db.path, os.O_RDWR|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
@@ -68,6 +165,19 @@ func (db *DB) writeDB(structure DBStructure) error {
 	return nil
 }
 
+func paginate[T any](items []T, offset, limit int) []T {
+	if offset >= len(items) {
+		return make([]T, 0)
+	}
+
+	end := len(items)
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+
+	return items[offset:end]
+}
+
 func NewDB(path string) (*DB, error) {
 	db := DB{
 		path: path,
@@ -84,7 +194,18 @@ func NewDB(path string) (*DB, error) {
 		return nil, loadErr
 	}
 
+	migratedTokens := dbStructure.migrateRefreshTokens(legacySessionTTL)
+	hashedTokens := dbStructure.hashSessionTokens()
+
+	if migratedTokens || hashedTokens {
+		writeErr := db.writeDB(dbStructure)
+		if writeErr != nil {
+			return nil, writeErr
+		}
+	}
+
 	db.dbStructure = dbStructure
+	db.buildUsernameIndex()
 
 	return &db, nil
 }
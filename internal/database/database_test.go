@@ -1,9 +1,12 @@
 package database
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestCreateDB(t *testing.T) {
@@ -54,13 +57,13 @@ func TestGetChirps(t *testing.T) {
 	}
 
 	for _, data := range testData {
-		_, createErr := db.CreateChirp(data.body, data.authorId)
+		_, createErr := db.CreateChirp(data.body, data.authorId, ChirpOptions{})
 		if createErr != nil {
 			t.Errorf("Error creating chirp: %v", createErr)
 		}
 	}
 
-	chirps, getErr := db.GetChirps()
+	chirps, getErr := db.GetChirps(0)
 	if getErr != nil {
 		t.Errorf("Error getting chirps: %v", getErr)
 	}
@@ -106,14 +109,14 @@ func TestGetChirp(t *testing.T) {
 		{body: "t4", authorId: testUser.Id},
 	}
 	for _, data := range testData {
-		_, createErr := db.CreateChirp(data.body, data.authorId)
+		_, createErr := db.CreateChirp(data.body, data.authorId, ChirpOptions{})
 		if createErr != nil {
 			t.Errorf("Error creating chirp: %v", createErr)
 		}
 	}
-	c, e := db.GetChirp(1)
+	c, e := db.GetChirp(1, 0)
 	fmt.Println(c, e)
-	chirp, getErr := db.GetChirp(len(testData))
+	chirp, getErr := db.GetChirp(len(testData), 0)
 	if getErr != nil {
 		t.Errorf("Error getting chirps: %v", getErr)
 	}
@@ -249,8 +252,8 @@ func TestUpdateUsers(t *testing.T) {
 	}
 }
 
-func TestCreateRefreshToken(t *testing.T) {
-	dbPath := "TestCreateRefreshToken.json"
+func TestCreateSession(t *testing.T) {
+	dbPath := "TestCreateSession.json"
 	db, newDBErr := NewDB(dbPath)
 	if newDBErr != nil {
 		t.Errorf("Error creating DB: %v", newDBErr)
@@ -258,26 +261,48 @@ func TestCreateRefreshToken(t *testing.T) {
 	if db == nil {
 		t.Errorf("DB is nil")
 	}
-	testData := []int{1, 2, 3}
 
-	for _, userId := range testData {
-		_, createErr := db.CreateRefreshToken(userId)
+	user, _ := db.CreateUser("session@example.com", "password")
+	devices := []string{"Laptop", "Phone", "Tablet"}
+	tokens := make([]string, 0)
+
+	for _, device := range devices {
+		session, createErr := db.CreateSession(user.Id, SessionInfo{DeviceLabel: device}, time.Hour)
 		if createErr != nil {
-			t.Errorf("Error creating refresh token: %v", createErr)
+			t.Errorf("Error creating session: %v", createErr)
 		}
-	}
 
-	for _, userId := range testData {
-		token, getErr := db.GetRefreshToken(userId)
-		if getErr != nil {
-			t.Errorf("Error getting refresh token: %v", getErr)
+		if len(session.Token) == 0 {
+			t.Errorf("Expected token, got empty string")
 		}
+		tokens = append(tokens, session.Token)
+	}
 
-		if len(token) == 0 {
-			t.Errorf("Expected token, got empty string")
+	sessions, getErr := db.GetSessions(user.Id)
+	if getErr != nil {
+		t.Errorf("Error getting sessions: %v", getErr)
+	}
+
+	if len(sessions) != len(devices) {
+		t.Errorf("Expected every device to keep its session, got %v", sessions)
+	}
+
+	for _, token := range tokens {
+		if used, useErr := db.RotateSession(token, "127.0.0.1", time.Hour); useErr != nil || used.UserId != user.Id {
+			t.Errorf("Expected session to be usable, got %v", useErr)
 		}
 	}
 
+	expired, _ := db.CreateSession(user.Id, SessionInfo{}, -time.Second)
+
+	if _, useErr := db.RotateSession(expired.Token, "", time.Hour); !errors.Is(useErr, ErrSessionNotFound) {
+		t.Errorf("Expected %v, got %v", ErrSessionNotFound, useErr)
+	}
+
+	if sessions, _ := db.GetSessions(user.Id); len(sessions) != len(devices) {
+		t.Errorf("Expected expired sessions to be hidden, got %v", sessions)
+	}
+
 	// Cleanup
 
 	removeErr := os.Remove(dbPath)
@@ -286,8 +311,8 @@ func TestCreateRefreshToken(t *testing.T) {
 	}
 }
 
-func TestDeleteeRefreshToken(t *testing.T) {
-	dbPath := "TestDeleteeRefreshToken.json"
+func TestDeleteSession(t *testing.T) {
+	dbPath := "TestDeleteSession.json"
 	db, newDBErr := NewDB(dbPath)
 	if newDBErr != nil {
 		t.Errorf("Error creating DB: %v", newDBErr)
@@ -295,35 +320,1242 @@ func TestDeleteeRefreshToken(t *testing.T) {
 	if db == nil {
 		t.Errorf("DB is nil")
 	}
-	testData := []int{1, 2, 3}
 
-	tokens := make([]string, 0)
+	user, _ := db.CreateUser("session@example.com", "password")
+	other, _ := db.CreateUser("other@example.com", "password")
 
-	for _, userId := range testData {
-		token, createErr := db.CreateRefreshToken(userId)
-		if createErr != nil {
-			t.Errorf("Error creating refresh token: %v", createErr)
-		}
-		tokens = append(tokens, token)
+	first, _ := db.CreateSession(user.Id, SessionInfo{DeviceLabel: "Laptop"}, time.Hour)
+	second, _ := db.CreateSession(user.Id, SessionInfo{DeviceLabel: "Phone"}, time.Hour)
+
+	if deleteErr := db.DeleteSession(other.Id, first.Id); !errors.Is(deleteErr, ErrSessionNotFound) {
+		t.Errorf("Expected %v, got %v", ErrSessionNotFound, deleteErr)
+	}
+
+	if deleteErr := db.DeleteSession(user.Id, first.Id); deleteErr != nil {
+		t.Errorf("Error deleting session: %v", deleteErr)
+	}
+
+	if _, useErr := db.RotateSession(first.Token, "", time.Hour); useErr == nil {
+		t.Errorf("Expected revoked session to be unusable")
+	}
+
+	second, useErr := db.RotateSession(second.Token, "", time.Hour)
+	if useErr != nil {
+		t.Errorf("Expected other session to survive, got %v", useErr)
+	}
+
+	if deleted, _ := db.DeleteSessionByToken(second.Token); deleted.Id != second.Id {
+		t.Errorf("Expected session %d to be deleted, got %v", second.Id, deleted)
+	}
+
+	if sessions, _ := db.GetSessions(user.Id); len(sessions) != 0 {
+		t.Errorf("Expected no sessions, got %v", sessions)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestMentionNotifications(t *testing.T) {
+	dbPath := "TestMentionNotifications.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, createAuthorErr := db.CreateUser("t1@naver.com", "1234")
+	if createAuthorErr != nil {
+		t.Errorf("Error creating user: %v", createAuthorErr)
+	}
+	mentioned, createMentionedErr := db.CreateUser("t2@naver.com", "1234")
+	if createMentionedErr != nil {
+		t.Errorf("Error creating user: %v", createMentionedErr)
+	}
+
+	username := "Walrus"
+	_, profileErr := db.UpdateProfile(mentioned.Id, ProfileUpdate{Username: &username})
+	if profileErr != nil {
+		t.Errorf("Error updating profile: %v", profileErr)
+	}
+
+	chirp, createErr := db.CreateChirp("hello @walrus and @walrus, not @nobody or me@walrus", author.Id, ChirpOptions{})
+	if createErr != nil {
+		t.Errorf("Error creating chirp: %v", createErr)
+	}
+
+	if len(chirp.Mentions) != 1 || chirp.Mentions[0] != mentioned.Id {
+		t.Errorf("Expected mentions [%d], got %v", mentioned.Id, chirp.Mentions)
+	}
+
+	notifications, total, getErr := db.GetNotifications(mentioned.Id, true, 0, 10)
+	if getErr != nil {
+		t.Errorf("Error getting notifications: %v", getErr)
+	}
+
+	if total != 1 || len(notifications) != 1 {
+		t.Fatalf("Expected 1 notification, got %d", total)
+	}
+
+	if notifications[0].Type != NotificationMention || notifications[0].ChirpId != chirp.Id || notifications[0].ActorId != author.Id {
+		t.Errorf("Unexpected notification %v", notifications[0])
+	}
+
+	markErr := db.MarkNotificationsRead(author.Id, []int{notifications[0].Id})
+	if markErr == nil {
+		t.Errorf("Expected error marking another user's notification")
+	}
+
+	markErr = db.MarkNotificationsRead(mentioned.Id, nil)
+	if markErr != nil {
+		t.Errorf("Error marking notifications read: %v", markErr)
+	}
+
+	if unread := db.CountUnreadNotifications(mentioned.Id); unread != 0 {
+		t.Errorf("Expected 0 unread notifications, got %d", unread)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	dbPath := "TestUpdateProfile.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	first, createFirstErr := db.CreateUser("t1@naver.com", "1234")
+	if createFirstErr != nil {
+		t.Errorf("Error creating user: %v", createFirstErr)
+	}
+	second, createSecondErr := db.CreateUser("t2@naver.com", "1234")
+	if createSecondErr != nil {
+		t.Errorf("Error creating user: %v", createSecondErr)
+	}
+
+	username := "Walrus"
+	bio := "hello"
+	_, updateErr := db.UpdateProfile(first.Id, ProfileUpdate{Username: &username, Bio: &bio})
+	if updateErr != nil {
+		t.Errorf("Error updating profile: %v", updateErr)
+	}
+
+	taken := "WALRUS"
+	_, takenErr := db.UpdateProfile(second.Id, ProfileUpdate{Username: &taken})
+	if !errors.Is(takenErr, ErrUsernameTaken) {
+		t.Errorf("Expected %v, got %v", ErrUsernameTaken, takenErr)
+	}
+
+	invalid := "a b"
+	_, invalidErr := db.UpdateProfile(second.Id, ProfileUpdate{Username: &invalid})
+	if !errors.Is(invalidErr, ErrInvalidUsername) {
+		t.Errorf("Expected %v, got %v", ErrInvalidUsername, invalidErr)
+	}
+
+	avatar := "javascript:alert(1)"
+	_, avatarErr := db.UpdateProfile(second.Id, ProfileUpdate{AvatarUrl: &avatar})
+	if !errors.Is(avatarErr, ErrInvalidProfile) {
+		t.Errorf("Expected %v, got %v", ErrInvalidProfile, avatarErr)
+	}
+
+	user, getErr := db.GetUserByUsername("wAlRuS")
+	if getErr != nil {
+		t.Errorf("Error getting user: %v", getErr)
+	}
+
+	if user.Id != first.Id || user.Bio != bio {
+		t.Errorf("Expected user %d with bio %v, got %v", first.Id, bio, user)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestChirpMedia(t *testing.T) {
+	dbPath := "TestChirpMedia.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	owner, createOwnerErr := db.CreateUser("t1@naver.com", "1234")
+	if createOwnerErr != nil {
+		t.Errorf("Error creating user: %v", createOwnerErr)
+	}
+	other, createOtherErr := db.CreateUser("t2@naver.com", "1234")
+	if createOtherErr != nil {
+		t.Errorf("Error creating user: %v", createOtherErr)
+	}
+
+	first, createErr := db.CreateMedia(owner.Id, Media{Hash: "a", Size: 60}, 100)
+	if createErr != nil {
+		t.Errorf("Error creating media: %v", createErr)
+	}
+
+	again, againErr := db.CreateMedia(owner.Id, Media{Hash: "a", Size: 60}, 100)
+	if againErr != nil || again.Id != first.Id {
+		t.Errorf("Expected media %d to be reused, got %v, %v", first.Id, again, againErr)
 	}
 
-	db.DeleteRefreshToken(tokens[0])
+	_, quotaErr := db.CreateMedia(owner.Id, Media{Hash: "b", Size: 60}, 100)
+	if !errors.Is(quotaErr, ErrQuotaExceeded) {
+		t.Errorf("Expected %v, got %v", ErrQuotaExceeded, quotaErr)
+	}
+
+	foreign, foreignErr := db.CreateMedia(other.Id, Media{Hash: "b", Size: 60}, 100)
+	if foreignErr != nil {
+		t.Errorf("Error creating media: %v", foreignErr)
+	}
+
+	chirp, chirpErr := db.CreateChirp("t1", owner.Id, ChirpOptions{MediaIds: []int{first.Id}})
+	if chirpErr != nil {
+		t.Errorf("Error creating chirp: %v", chirpErr)
+	}
+	if len(chirp.MediaIds) != 1 {
+		t.Errorf("Expected 1 media id, got %v", chirp.MediaIds)
+	}
+
+	_, foreignChirpErr := db.CreateChirp("t2", owner.Id, ChirpOptions{MediaIds: []int{foreign.Id}})
+	if !errors.Is(foreignChirpErr, ErrInvalidChirp) {
+		t.Errorf("Expected %v, got %v", ErrInvalidChirp, foreignChirpErr)
+	}
+
+	_, tooManyErr := db.CreateChirp("t3", owner.Id, ChirpOptions{MediaIds: []int{1, 2, 3, 4, 5}})
+	if !errors.Is(tooManyErr, ErrInvalidChirp) {
+		t.Errorf("Expected %v, got %v", ErrInvalidChirp, tooManyErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestScheduledChirps(t *testing.T) {
+	dbPath := "TestScheduledChirps.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, createAuthorErr := db.CreateUser("t1@naver.com", "1234")
+	if createAuthorErr != nil {
+		t.Errorf("Error creating user: %v", createAuthorErr)
+	}
+
+	publishAt := time.Now().Add(time.Hour)
+	chirp, createErr := db.CreateChirp("t1", author.Id, ChirpOptions{PublishAt: &publishAt})
+	if createErr != nil {
+		t.Errorf("Error creating chirp: %v", createErr)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	_, pastErr := db.CreateChirp("t2", author.Id, ChirpOptions{PublishAt: &past})
+	if !errors.Is(pastErr, ErrInvalidChirp) {
+		t.Errorf("Expected %v, got %v", ErrInvalidChirp, pastErr)
+	}
+
+	if chirps, _ := db.GetChirps(0); len(chirps) != 0 {
+		t.Errorf("Expected pending chirp to be hidden, got %v", chirps)
+	}
+
+	if _, getErr := db.GetChirp(chirp.Id, author.Id); getErr != nil {
+		t.Errorf("Expected author to see pending chirp: %v", getErr)
+	}
+
+	published, publishErr := db.PublishDueChirps(time.Now())
+	if publishErr != nil || len(published) != 0 {
+		t.Errorf("Expected nothing to publish, got %v, %v", published, publishErr)
+	}
+
+	// Reopen the database to make sure pending chirps survive a restart.
+	db, newDBErr = NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error reopening DB: %v", newDBErr)
+	}
+
+	published, publishErr = db.PublishDueChirps(publishAt)
+	if publishErr != nil || len(published) != 1 {
+		t.Errorf("Expected 1 published chirp, got %v, %v", published, publishErr)
+	}
+
+	if chirps, _ := db.GetChirps(0); len(chirps) != 1 {
+		t.Errorf("Expected 1 chirp, got %v", chirps)
+	}
+
+	cancelErr := db.CancelScheduledChirp(chirp.Id, author.Id)
+	if cancelErr == nil {
+		t.Errorf("Expected error cancelling a published chirp")
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestPublishDraft(t *testing.T) {
+	dbPath := "TestPublishDraft.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
 
-	for _, userId := range testData {
-		token, getErr := db.GetRefreshToken(userId)
-		if userId == testData[0] {
-			if len(token) != 0 {
-				t.Errorf("%v's token must be deleted.", userId)
-			}
-		} else {
-			if getErr != nil {
-				t.Errorf("Error getting refresh token: %v", getErr)
-			}
+	author, createAuthorErr := db.CreateUser("t1@naver.com", "1234")
+	if createAuthorErr != nil {
+		t.Errorf("Error creating user: %v", createAuthorErr)
+	}
+	other, createOtherErr := db.CreateUser("t2@naver.com", "1234")
+	if createOtherErr != nil {
+		t.Errorf("Error creating user: %v", createOtherErr)
+	}
+
+	existing, createChirpErr := db.CreateChirp("t0", author.Id, ChirpOptions{})
+	if createChirpErr != nil {
+		t.Errorf("Error creating chirp: %v", createChirpErr)
+	}
+
+	draft, createErr := db.CreateDraft("t1", author.Id, nil)
+	if createErr != nil {
+		t.Errorf("Error creating draft: %v", createErr)
+	}
+
+	updated, updateErr := db.UpdateDraft(draft.Id, author.Id, "t2", nil)
+	if updateErr != nil {
+		t.Errorf("Error updating draft: %v", updateErr)
+	}
+
+	if _, getErr := db.GetDraft(draft.Id, other.Id); getErr == nil {
+		t.Errorf("Expected another user's draft to be hidden")
+	}
+
+	if _, publishErr := db.PublishDraft(draft.Id, other.Id, updated.UpdatedAt, "t2", ChirpOptions{}); publishErr == nil {
+		t.Errorf("Expected error publishing another user's draft")
+	}
+
+	if _, staleErr := db.PublishDraft(draft.Id, author.Id, draft.UpdatedAt, "t1", ChirpOptions{}); !errors.Is(staleErr, ErrDraftChanged) {
+		t.Errorf("Expected %v publishing an edited draft, got %v", ErrDraftChanged, staleErr)
+	}
+
+	chirp, publishErr := db.PublishDraft(draft.Id, author.Id, updated.UpdatedAt, "t2", ChirpOptions{})
+	if publishErr != nil {
+		t.Errorf("Error publishing draft: %v", publishErr)
+	}
+
+	if chirp.Id == existing.Id || chirp.Body != "t2" {
+		t.Errorf("Expected a new chirp with the draft body, got %v", chirp)
+	}
+
+	if drafts, _ := db.GetDrafts(author.Id); len(drafts) != 0 {
+		t.Errorf("Expected draft to be removed, got %v", drafts)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestChirpVisibility(t *testing.T) {
+	dbPath := "TestChirpVisibility.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, _ := db.CreateUser("t1@naver.com", "1234")
+	follower, _ := db.CreateUser("t2@naver.com", "1234")
+	stranger, _ := db.CreateUser("t3@naver.com", "1234")
+
+	_, followErr := db.FollowUser(follower.Id, author.Id)
+	if followErr != nil {
+		t.Errorf("Error following user: %v", followErr)
+	}
+
+	public, _ := db.CreateChirp("t1", author.Id, ChirpOptions{})
+	followersOnly, _ := db.CreateChirp("t2", author.Id, ChirpOptions{Visibility: VisibilityFollowers})
+	private, _ := db.CreateChirp("t3", author.Id, ChirpOptions{Visibility: VisibilityPrivate})
+
+	_, invalidErr := db.CreateChirp("t4", author.Id, ChirpOptions{Visibility: "friends"})
+	if !errors.Is(invalidErr, ErrInvalidChirp) {
+		t.Errorf("Expected %v, got %v", ErrInvalidChirp, invalidErr)
+	}
+
+	testData := []struct {
+		viewerId int
+		expected int
+	}{
+		{viewerId: 0, expected: 1},
+		{viewerId: stranger.Id, expected: 1},
+		{viewerId: follower.Id, expected: 2},
+		{viewerId: author.Id, expected: 3},
+	}
 
-			if len(token) == 0 {
-				t.Errorf("Expected token, got empty string")
-			}
+	for _, data := range testData {
+		chirps, getErr := db.GetChirpsByAuthorId(author.Id, data.viewerId)
+		if getErr != nil {
+			t.Errorf("Error getting chirps: %v", getErr)
 		}
+		if len(chirps) != data.expected {
+			t.Errorf("Expected %d chirps for viewer %d, got %d", data.expected, data.viewerId, len(chirps))
+		}
+	}
+
+	if public.Visibility != VisibilityPublic {
+		t.Errorf("Expected default visibility %v, got %v", VisibilityPublic, public.Visibility)
+	}
+
+	if _, getErr := db.GetChirp(followersOnly.Id, stranger.Id); getErr == nil {
+		t.Errorf("Expected followers-only chirp to be hidden from strangers")
+	}
+
+	if _, getErr := db.GetChirp(private.Id, follower.Id); getErr == nil {
+		t.Errorf("Expected private chirp to be hidden from followers")
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestPollVotes(t *testing.T) {
+	dbPath := "TestPollVotes.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, _ := db.CreateUser("t1@naver.com", "1234")
+	voter, _ := db.CreateUser("t2@naver.com", "1234")
+
+	_, tooFewErr := db.CreateChirp("t1", author.Id, ChirpOptions{PollOptions: []string{"a"}, PollClosesAt: time.Now().Add(time.Hour)})
+	if !errors.Is(tooFewErr, ErrInvalidChirp) {
+		t.Errorf("Expected %v, got %v", ErrInvalidChirp, tooFewErr)
+	}
+
+	chirp, createErr := db.CreateChirp("t2", author.Id, ChirpOptions{PollOptions: []string{"a", "b", "c"}, PollClosesAt: time.Now().Add(time.Hour)})
+	if createErr != nil {
+		t.Fatalf("Error creating chirp: %v", createErr)
+	}
+
+	if chirp.Poll.Tallies != nil {
+		t.Errorf("Expected tallies to be hidden before voting, got %v", chirp.Poll.Tallies)
+	}
+
+	voted, voteErr := db.Vote(chirp.Id, voter.Id, 1)
+	if voteErr != nil {
+		t.Fatalf("Error voting: %v", voteErr)
+	}
+
+	if len(voted.Poll.Tallies) != 3 || voted.Poll.Tallies[1] != 1 || *voted.Poll.VotedOption != 1 {
+		t.Errorf("Expected tallies after voting, got %v", voted.Poll)
+	}
+
+	if _, againErr := db.Vote(chirp.Id, voter.Id, 0); !errors.Is(againErr, ErrAlreadyVoted) {
+		t.Errorf("Expected %v, got %v", ErrAlreadyVoted, againErr)
+	}
+
+	if _, invalidErr := db.Vote(chirp.Id, author.Id, 3); !errors.Is(invalidErr, ErrInvalidVote) {
+		t.Errorf("Expected %v, got %v", ErrInvalidVote, invalidErr)
+	}
+
+	if anonymous, _ := db.GetChirp(chirp.Id, 0); anonymous.Poll.Tallies != nil {
+		t.Errorf("Expected tallies to be hidden from non-voters, got %v", anonymous.Poll.Tallies)
+	}
+
+	closed := db.dbStructure.Chirps[chirp.Id]
+	closed.Poll.ClosesAt = time.Now().Add(-time.Minute)
+	db.dbStructure.Chirps[chirp.Id] = closed
+
+	if _, closedErr := db.Vote(chirp.Id, author.Id, 0); !errors.Is(closedErr, ErrPollClosed) {
+		t.Errorf("Expected %v, got %v", ErrPollClosed, closedErr)
+	}
+
+	if anonymous, _ := db.GetChirp(chirp.Id, 0); !anonymous.Poll.Closed || anonymous.Poll.Tallies == nil {
+		t.Errorf("Expected tallies once the poll closed, got %v", anonymous.Poll)
+	}
+
+	// Purging the newest chirp doesn't hand its id or votes to the next one.
+	db.DeleteChirp(chirp.Id)
+	db.PurgeDeletedChirps(time.Now())
+
+	if len(db.dbStructure.Votes) != 0 {
+		t.Errorf("Expected purge to remove the chirp's votes, got %v", db.dbStructure.Votes)
+	}
+
+	next, _ := db.CreateChirp("t3", author.Id, ChirpOptions{PollOptions: []string{"a", "b"}, PollClosesAt: time.Now().Add(time.Hour)})
+	if next.Id == chirp.Id {
+		t.Errorf("Expected a new id after purging chirp %d", chirp.Id)
+	}
+
+	if _, voteErr := db.Vote(next.Id, voter.Id, 0); voteErr != nil {
+		t.Errorf("Expected a fresh poll to accept the vote, got %v", voteErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestBookmarksAndPins(t *testing.T) {
+	dbPath := "TestBookmarksAndPins.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, _ := db.CreateUser("t1@naver.com", "1234")
+	reader, _ := db.CreateUser("t2@naver.com", "1234")
+
+	first, _ := db.CreateChirp("t1", author.Id, ChirpOptions{})
+	second, _ := db.CreateChirp("t2", author.Id, ChirpOptions{})
+	private, _ := db.CreateChirp("t3", author.Id, ChirpOptions{Visibility: VisibilityPrivate})
+
+	for _, chirp := range []Chirp{first, second} {
+		if _, bookmarkErr := db.CreateBookmark(reader.Id, chirp.Id); bookmarkErr != nil {
+			t.Errorf("Error bookmarking chirp: %v", bookmarkErr)
+		}
+	}
+
+	if _, hiddenErr := db.CreateBookmark(reader.Id, private.Id); hiddenErr == nil {
+		t.Errorf("Expected error bookmarking a hidden chirp")
+	}
+
+	chirps, total, getErr := db.GetBookmarkedChirps(reader.Id, 0, 1)
+	if getErr != nil {
+		t.Errorf("Error getting bookmarks: %v", getErr)
+	}
+	if total != 2 || len(chirps) != 1 || chirps[0].Id != second.Id {
+		t.Errorf("Expected newest bookmark first out of 2, got %v of %d", chirps, total)
+	}
+
+	othersChirp := second.Id
+	if _, pinErr := db.UpdateProfile(reader.Id, ProfileUpdate{PinnedChirpId: &othersChirp}); !errors.Is(pinErr, ErrInvalidProfile) {
+		t.Errorf("Expected %v, got %v", ErrInvalidProfile, pinErr)
+	}
+
+	if _, pinErr := db.UpdateProfile(author.Id, ProfileUpdate{PinnedChirpId: &othersChirp}); pinErr != nil {
+		t.Errorf("Error pinning chirp: %v", pinErr)
+	}
+
+	if deleteErr := db.DeleteChirp(second.Id); deleteErr != nil {
+		t.Errorf("Error deleting chirp: %v", deleteErr)
+	}
+
+	if user, _ := db.GetUser(author.Id); user.PinnedChirpId != 0 {
+		t.Errorf("Expected deleted chirp to be unpinned, got %d", user.PinnedChirpId)
+	}
+
+	if _, total, _ := db.GetBookmarkedChirps(reader.Id, 0, 10); total != 1 {
+		t.Errorf("Expected 1 bookmark after deleting a chirp, got %d", total)
+	}
+
+	if len(db.dbStructure.Bookmarks) != 1 {
+		t.Errorf("Expected DeleteChirp to remove its bookmarks, got %v", db.dbStructure.Bookmarks)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestBlockAndMute(t *testing.T) {
+	dbPath := "TestBlockAndMute.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, _ := db.CreateUser("t1@naver.com", "1234")
+	blocker, _ := db.CreateUser("t2@naver.com", "1234")
+	muter, _ := db.CreateUser("t3@naver.com", "1234")
+
+	username := "blocker"
+	db.UpdateProfile(blocker.Id, ProfileUpdate{Username: &username})
+
+	db.FollowUser(blocker.Id, author.Id)
+
+	if _, blockErr := db.CreateRelation(blocker.Id, author.Id, RelationBlock); blockErr != nil {
+		t.Errorf("Error blocking user: %v", blockErr)
+	}
+	if _, muteErr := db.CreateRelation(muter.Id, author.Id, RelationMute); muteErr != nil {
+		t.Errorf("Error muting user: %v", muteErr)
+	}
+
+	if db.IsFollowing(blocker.Id, author.Id) {
+		t.Errorf("Expected blocking to remove the follow")
+	}
+
+	if _, followErr := db.FollowUser(author.Id, blocker.Id); !errors.Is(followErr, ErrBlocked) {
+		t.Errorf("Expected %v, got %v", ErrBlocked, followErr)
+	}
+
+	chirp, _ := db.CreateChirp("hi @blocker", author.Id, ChirpOptions{})
+	if len(chirp.Mentions) != 0 {
+		t.Errorf("Expected blocked mention to be dropped, got %v", chirp.Mentions)
+	}
+
+	if _, getErr := db.GetChirp(chirp.Id, blocker.Id); getErr == nil {
+		t.Errorf("Expected chirp to be hidden from the blocker")
+	}
+
+	if chirps, _ := db.GetChirps(muter.Id); len(chirps) != 0 {
+		t.Errorf("Expected muted author to be filtered from listings, got %v", chirps)
+	}
+
+	if _, getErr := db.GetChirp(chirp.Id, muter.Id); getErr != nil {
+		t.Errorf("Expected muted author's chirp to stay reachable: %v", getErr)
+	}
+
+	if deleteErr := db.DeleteRelation(blocker.Id, author.Id, RelationBlock); deleteErr != nil {
+		t.Errorf("Error unblocking user: %v", deleteErr)
+	}
+
+	if _, getErr := db.GetChirp(chirp.Id, blocker.Id); getErr != nil {
+		t.Errorf("Expected chirp to be visible after unblocking: %v", getErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestModerateReport(t *testing.T) {
+	dbPath := "TestModerateReport.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, _ := db.CreateUser("t1@naver.com", "1234")
+	reporter, _ := db.CreateUser("t2@naver.com", "1234")
+	admin, _ := db.CreateUser("admin@naver.com", "1234")
+
+	if adminErr := db.SetAdmin(admin.Email, true); adminErr != nil {
+		t.Errorf("Error granting admin: %v", adminErr)
+	}
+
+	chirp, _ := db.CreateChirp("t1", author.Id, ChirpOptions{})
+
+	if _, invalidErr := db.CreateReport(chirp.Id, reporter.Id, "boring", ""); !errors.Is(invalidErr, ErrInvalidReport) {
+		t.Errorf("Expected %v, got %v", ErrInvalidReport, invalidErr)
+	}
+
+	report, reportErr := db.CreateReport(chirp.Id, reporter.Id, ReportSpam, "buy now")
+	if reportErr != nil {
+		t.Fatalf("Error creating report: %v", reportErr)
+	}
+
+	if _, notAdminErr := db.ModerateReport(report.Id, reporter.Id, ModerationHideChirp, ""); notAdminErr == nil {
+		t.Errorf("Expected non-admins to be refused")
+	}
+
+	if _, hideErr := db.ModerateReport(report.Id, admin.Id, ModerationHideChirp, ""); hideErr != nil {
+		t.Errorf("Error hiding chirp: %v", hideErr)
+	}
+
+	if _, getErr := db.GetChirp(chirp.Id, reporter.Id); getErr == nil {
+		t.Errorf("Expected hidden chirp to be invisible")
+	}
+
+	session, _ := db.CreateSession(author.Id, SessionInfo{}, time.Hour)
+	pat, _ := db.CreatePersonalAccessToken(author.Id, "bot", []string{"chirps:write"}, nil)
+
+	if _, suspendErr := db.ModerateReport(report.Id, admin.Id, ModerationSuspendUser, ""); suspendErr != nil {
+		t.Errorf("Error suspending user: %v", suspendErr)
+	}
+
+	if _, rotateErr := db.RotateSession(session.Token, "", time.Hour); rotateErr == nil {
+		t.Errorf("Expected suspension to end the user's sessions")
+	}
+
+	if _, lookupErr := db.LookupPersonalAccessToken(pat.Token); lookupErr == nil {
+		t.Errorf("Expected suspension to revoke personal access tokens")
+	}
+
+	if !db.IsAccessTokenRevoked("a", author.Id, time.Now().Add(-time.Minute)) {
+		t.Errorf("Expected suspension to revoke access tokens")
+	}
+
+	if _, loginErr := db.LoginUser(author.Email, "1234"); !errors.Is(loginErr, ErrSuspended) {
+		t.Errorf("Expected %v, got %v", ErrSuspended, loginErr)
+	}
+
+	closed, closeErr := db.ModerateReport(report.Id, admin.Id, ModerationCloseReport, "spam removed")
+	if closeErr != nil || closed.Status != ReportClosed || closed.ClosedBy != admin.Id {
+		t.Errorf("Expected report closed by admin, got %v, %v", closed, closeErr)
+	}
+
+	if _, againErr := db.ModerateReport(report.Id, admin.Id, ModerationRemoveChirp, ""); !errors.Is(againErr, ErrReportClosed) {
+		t.Errorf("Expected %v, got %v", ErrReportClosed, againErr)
+	}
+
+	actions, _ := db.GetModerationActions()
+	if len(actions) != 3 || actions[0].AdminId != admin.Id || actions[0].Action != ModerationCloseReport {
+		t.Errorf("Expected 3 logged actions, newest first, got %v", actions)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestSoftDeleteChirp(t *testing.T) {
+	dbPath := "TestSoftDeleteChirp.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+	if db == nil {
+		t.Errorf("DB is nil")
+	}
+
+	author, _ := db.CreateUser("t1@naver.com", "1234")
+	chirp, _ := db.CreateChirp("t1", author.Id, ChirpOptions{})
+
+	if deleteErr := db.DeleteChirp(chirp.Id); deleteErr != nil {
+		t.Errorf("Error deleting chirp: %v", deleteErr)
+	}
+
+	if _, getErr := db.GetChirp(chirp.Id, author.Id); getErr == nil {
+		t.Errorf("Expected deleted chirp to be hidden from its author")
+	}
+
+	if trash, _ := db.GetDeletedChirps(author.Id); len(trash) != 1 {
+		t.Errorf("Expected 1 chirp in the trash, got %v", trash)
+	}
+
+	if _, expiredErr := db.RestoreChirp(chirp.Id, author.Id, 0); !errors.Is(expiredErr, ErrRestoreExpired) {
+		t.Errorf("Expected %v, got %v", ErrRestoreExpired, expiredErr)
+	}
+
+	if _, restoreErr := db.RestoreChirp(chirp.Id, author.Id, time.Hour); restoreErr != nil {
+		t.Errorf("Error restoring chirp: %v", restoreErr)
+	}
+
+	if _, getErr := db.GetChirp(chirp.Id, 0); getErr != nil {
+		t.Errorf("Expected restored chirp to be visible: %v", getErr)
+	}
+
+	db.DeleteChirp(chirp.Id)
+
+	if purged, _ := db.PurgeDeletedChirps(time.Now().Add(-time.Hour)); purged != 0 {
+		t.Errorf("Expected nothing purged inside the retention window, got %d", purged)
+	}
+
+	if purged, _ := db.PurgeDeletedChirps(time.Now()); purged != 1 {
+		t.Errorf("Expected 1 purged chirp, got %d", purged)
+	}
+
+	if _, restoreErr := db.RestoreChirp(chirp.Id, author.Id, time.Hour); restoreErr == nil {
+		t.Errorf("Expected purged chirp to be gone")
+	}
+
+	// A scheduled chirp in the trash is not published, and nobody it
+	// mentions is notified.
+	mentioned, _ := db.CreateUser("t2@naver.com", "1234")
+	username := "mentioned"
+	db.UpdateProfile(mentioned.Id, ProfileUpdate{Username: &username})
+	publishAt := time.Now().Add(time.Hour)
+	scheduled, _ := db.CreateChirp("hi @mentioned", author.Id, ChirpOptions{PublishAt: &publishAt})
+	db.DeleteChirp(scheduled.Id)
+
+	if published, _ := db.PublishDueChirps(publishAt); len(published) != 0 {
+		t.Errorf("Expected deleted scheduled chirp not to be published, got %v", published)
+	}
+
+	if notifications, _, _ := db.GetNotifications(mentioned.Id, false, 0, 10); len(notifications) != 0 {
+		t.Errorf("Expected no mention notification, got %v", notifications)
+	}
+
+	if scheduledChirps, _ := db.GetScheduledChirps(author.Id); len(scheduledChirps) != 0 {
+		t.Errorf("Expected deleted chirp to leave the schedule, got %v", scheduledChirps)
+	}
+
+	if cancelErr := db.CancelScheduledChirp(scheduled.Id, author.Id); cancelErr == nil {
+		t.Errorf("Expected error cancelling a deleted chirp")
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestIdempotentRequests(t *testing.T) {
+	const dbPath = "TestIdempotentRequests.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	const key = "1:POST /api/chirps:abc"
+
+	if _, replay, beginErr := db.BeginIdempotentRequest(key, "hash", time.Hour); replay || beginErr != nil {
+		t.Errorf("Expected a fresh reservation, got %v, %v", replay, beginErr)
+	}
+
+	if _, _, beginErr := db.BeginIdempotentRequest(key, "hash", time.Hour); !errors.Is(beginErr, ErrIdempotencyInProgress) {
+		t.Errorf("Expected %v, got %v", ErrIdempotencyInProgress, beginErr)
+	}
+
+	if completeErr := db.CompleteIdempotentRequest(key, 201, "application/json", []byte(`{"id":1}`)); completeErr != nil {
+		t.Errorf("Error completing request: %v", completeErr)
+	}
+
+	record, replay, beginErr := db.BeginIdempotentRequest(key, "hash", time.Hour)
+	if !replay || beginErr != nil || record.StatusCode != 201 || string(record.Body) != `{"id":1}` {
+		t.Errorf("Expected stored response to be replayed, got %v, %v, %v", record, replay, beginErr)
+	}
+
+	if _, _, beginErr := db.BeginIdempotentRequest(key, "other", time.Hour); !errors.Is(beginErr, ErrIdempotencyMismatch) {
+		t.Errorf("Expected %v, got %v", ErrIdempotencyMismatch, beginErr)
+	}
+
+	if purged, _ := db.PurgeExpiredIdempotencyKeys(time.Now().Add(2 * time.Hour)); purged != 1 {
+		t.Errorf("Expected 1 purged key, got %d", purged)
+	}
+
+	if _, replay, _ := db.BeginIdempotentRequest(key, "other", time.Hour); replay {
+		t.Errorf("Expected expired key to be reusable")
+	}
+
+	// Logins store only the session, which a replay reissues.
+	const loginKey = "0:POST /api/login:abc"
+	user, _ := db.CreateUser("idempotent@example.com", "1234")
+	session, _ := db.CreateSession(user.Id, SessionInfo{}, time.Hour)
+
+	db.BeginIdempotentRequest(loginKey, "hash", time.Hour)
+	db.CompleteIdempotentLogin(loginKey, 200, session.Id)
+
+	loginRecord, loginReplay, _ := db.BeginIdempotentRequest(loginKey, "hash", time.Hour)
+	if !loginReplay || loginRecord.SessionId != session.Id || len(loginRecord.Body) != 0 {
+		t.Errorf("Expected the login's session to be stored, got %v", loginRecord)
+	}
+
+	reissued, reissueErr := db.ReissueSession(loginRecord.SessionId, time.Hour)
+	if reissueErr != nil || reissued.Id != session.Id || reissued.Token == session.Token {
+		t.Errorf("Expected a new token for the same session, got %v, %v", reissued, reissueErr)
+	}
+
+	if _, rotateErr := db.RotateSession(session.Token, "", time.Hour); !errors.Is(rotateErr, ErrSessionNotFound) {
+		t.Errorf("Expected the replaced token to stop working without revoking, got %v", rotateErr)
+	}
+
+	if _, rotateErr := db.RotateSession(reissued.Token, "", time.Hour); rotateErr != nil {
+		t.Errorf("Expected the reissued token to work, got %v", rotateErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestMigrateRefreshTokens(t *testing.T) {
+	dbPath := "TestMigrateRefreshTokens.json"
+	writeErr := os.WriteFile(dbPath, []byte(`{"chirps":{}, "users":{"1":{"id":1,"email":"a@example.com"}}, "refreshTokens":{"1":"legacy"}}`), 0644)
+	if writeErr != nil {
+		t.Errorf("Error writing DB: %v", writeErr)
+	}
+
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	if data, _ := os.ReadFile(dbPath); strings.Contains(string(data), `"legacy"`) {
+		t.Errorf("Expected loading to write the migrated sessions, got %s", data)
+	}
+
+	session, useErr := db.RotateSession("legacy", "", time.Hour)
+	if useErr != nil || session.UserId != 1 {
+		t.Errorf("Expected legacy token to become a session, got %v, %v", session, useErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestRotateSession(t *testing.T) {
+	dbPath := "TestRotateSession.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	user, _ := db.CreateUser("session@example.com", "password")
+	session, _ := db.CreateSession(user.Id, SessionInfo{DeviceLabel: "Laptop"}, time.Hour)
+	other, _ := db.CreateSession(user.Id, SessionInfo{DeviceLabel: "Phone"}, time.Hour)
+
+	rotated, rotateErr := db.RotateSession(session.Token, "", 2*time.Hour)
+	if rotateErr != nil {
+		t.Errorf("Error rotating session: %v", rotateErr)
+	}
+
+	if rotated.Id != session.Id || rotated.Token == session.Token {
+		t.Errorf("Expected a new token for the same session, got %v", rotated)
+	}
+
+	if !rotated.ExpiresAt.After(session.ExpiresAt) {
+		t.Errorf("Expected rotation to extend the expiry")
+	}
+
+	if revoked, reuseErr := db.RotateSession(session.Token, "", time.Hour); !errors.Is(reuseErr, ErrTokenReused) || revoked.Id != session.Id {
+		t.Errorf("Expected %v for session %d, got %v %v", ErrTokenReused, session.Id, revoked.Id, reuseErr)
+	}
+
+	if _, rotateErr := db.RotateSession(rotated.Token, "", time.Hour); !errors.Is(rotateErr, ErrSessionNotFound) {
+		t.Errorf("Expected reuse to revoke the session, got %v", rotateErr)
+	}
+
+	if _, rotateErr := db.RotateSession(other.Token, "", time.Hour); rotateErr != nil {
+		t.Errorf("Expected other sessions to survive, got %v", rotateErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestHashSessionTokens(t *testing.T) {
+	dbPath := "TestHashSessionTokens.json"
+	writeErr := os.WriteFile(dbPath, []byte(`{"users":{"1":{"id":1}}, "sessions":{"1":{"id":1,"user_id":1,"token":"plain","retired_tokens":["old"],"expires_at":"2999-01-01T00:00:00Z"}}}`), 0644)
+	if writeErr != nil {
+		t.Errorf("Error writing DB: %v", writeErr)
+	}
+
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	if data, _ := os.ReadFile(dbPath); strings.Contains(string(data), "plain") {
+		t.Errorf("Expected loading to write the migrated tokens, got %s", data)
+	}
+
+	session, _ := db.CreateSession(1, SessionInfo{}, time.Hour)
+
+	data, _ := os.ReadFile(dbPath)
+	if strings.Contains(string(data), "plain") || strings.Contains(string(data), session.Token) {
+		t.Errorf("Expected only token hashes to be stored, got %s", data)
+	}
+
+	if _, rotateErr := db.RotateSession("plain", "", time.Hour); rotateErr != nil {
+		t.Errorf("Expected migrated token to work, got %v", rotateErr)
+	}
+
+	if _, reuseErr := db.RotateSession("old", "", time.Hour); !errors.Is(reuseErr, ErrTokenReused) {
+		t.Errorf("Expected %v, got %v", ErrTokenReused, reuseErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestRevokeAccessTokens(t *testing.T) {
+	dbPath := "TestRevokeAccessTokens.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	user, _ := db.CreateUser("revoke@example.com", "password")
+	db.CreateSession(user.Id, SessionInfo{}, time.Hour)
+	pat, _ := db.CreatePersonalAccessToken(user.Id, "bot", []string{"chirps:write"}, nil)
+	issuedAt := time.Now().Add(-time.Minute)
+
+	if db.IsAccessTokenRevoked("a", user.Id, issuedAt) {
+		t.Errorf("Expected token to be valid")
+	}
+
+	db.RevokeAccessToken("a", time.Now().Add(time.Hour))
+
+	if !db.IsAccessTokenRevoked("a", user.Id, issuedAt) || db.IsAccessTokenRevoked("b", user.Id, issuedAt) {
+		t.Errorf("Expected only the denied token to be revoked")
+	}
+
+	db.UpdateUser(user.Id, "", "new password", false)
+
+	if !db.IsAccessTokenRevoked("b", user.Id, issuedAt) {
+		t.Errorf("Expected a password change to revoke older tokens")
+	}
+
+	if db.IsAccessTokenRevoked("c", user.Id, time.Now().Add(time.Second)) {
+		t.Errorf("Expected tokens issued after the password change to be valid")
+	}
+
+	if sessions, _ := db.GetSessions(user.Id); len(sessions) != 0 {
+		t.Errorf("Expected a password change to end all sessions, got %v", sessions)
+	}
+
+	if _, lookupErr := db.LookupPersonalAccessToken(pat.Token); lookupErr == nil {
+		t.Errorf("Expected a password change to revoke personal access tokens")
+	}
+
+	if purged, _ := db.PurgeExpiredRevocations(time.Now().Add(2 * time.Hour)); purged != 1 {
+		t.Errorf("Expected 1 purged revocation, got %d", purged)
+	}
+
+	db.DeleteUser(user.Id)
+
+	if !db.IsAccessTokenRevoked("c", user.Id, time.Now()) {
+		t.Errorf("Expected tokens of a deleted user to be revoked")
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestPersonalAccessTokens(t *testing.T) {
+	dbPath := "TestPersonalAccessTokens.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	user, _ := db.CreateUser("bot@example.com", "password")
+	past := time.Now().Add(-time.Hour)
+
+	if _, createErr := db.CreatePersonalAccessToken(user.Id, " ", []string{"chirps:read"}, nil); !errors.Is(createErr, ErrInvalidAccessToken) {
+		t.Errorf("Expected %v for an empty name, got %v", ErrInvalidAccessToken, createErr)
+	}
+
+	if _, createErr := db.CreatePersonalAccessToken(user.Id, "bot", nil, nil); !errors.Is(createErr, ErrInvalidAccessToken) {
+		t.Errorf("Expected %v without scopes, got %v", ErrInvalidAccessToken, createErr)
+	}
+
+	if _, createErr := db.CreatePersonalAccessToken(user.Id, "bot", []string{"chirps:read"}, &past); !errors.Is(createErr, ErrInvalidAccessToken) {
+		t.Errorf("Expected %v for a past expiry, got %v", ErrInvalidAccessToken, createErr)
+	}
+
+	pat, createErr := db.CreatePersonalAccessToken(user.Id, "bot", []string{"chirps:write"}, nil)
+	if createErr != nil {
+		t.Errorf("Error creating token: %v", createErr)
+	}
+
+	if !strings.HasPrefix(pat.Token, PersonalAccessTokenPrefix) {
+		t.Errorf("Expected token to start with %s, got %s", PersonalAccessTokenPrefix, pat.Token)
+	}
+
+	used, useErr := db.UsePersonalAccessToken(pat.Token)
+	if useErr != nil || used.UserId != user.Id || used.LastUsedAt == nil {
+		t.Errorf("Expected token to be usable and marked used, got %v, %v", used, useErr)
+	}
+
+	if tokens, _ := db.GetPersonalAccessTokens(user.Id); len(tokens) != 1 || len(tokens[0].Token) != 0 {
+		t.Errorf("Expected one listed token without its secret, got %v", tokens)
+	}
+
+	if deleteErr := db.DeletePersonalAccessToken(user.Id, pat.Id); deleteErr != nil {
+		t.Errorf("Error deleting token: %v", deleteErr)
+	}
+
+	if _, lookupErr := db.LookupPersonalAccessToken(pat.Token); !errors.Is(lookupErr, ErrAccessTokenNotFound) {
+		t.Errorf("Expected %v, got %v", ErrAccessTokenNotFound, lookupErr)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestOAuthCodes(t *testing.T) {
+	dbPath := "TestOAuthCodes.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	user, _ := db.CreateUser("oauth@example.com", "password")
+	redirectURI := "https://app.example.com/callback"
+
+	if _, createErr := db.CreateOAuthClient(user.Id, "app", []string{"/callback"}, false); !errors.Is(createErr, ErrInvalidOAuthClient) {
+		t.Errorf("Expected %v for a relative redirect URI, got %v", ErrInvalidOAuthClient, createErr)
+	}
+
+	client, createErr := db.CreateOAuthClient(user.Id, "app", []string{redirectURI}, true)
+	if createErr != nil {
+		t.Errorf("Error creating client: %v", createErr)
+	}
+
+	if _, authErr := db.AuthenticateOAuthClient(client.ClientId, ""); !errors.Is(authErr, ErrInvalidOAuthClient) {
+		t.Errorf("Expected confidential client without secret to fail, got %v", authErr)
+	}
+
+	if _, authErr := db.AuthenticateOAuthClient(client.ClientId, client.Secret); authErr != nil {
+		t.Errorf("Error authenticating client: %v", authErr)
+	}
+
+	verifier := strings.Repeat("a", 43)
+	code, codeErr := db.CreateOAuthCode(client.ClientId, user.Id, redirectURI, []string{"chirps:read"}, PKCEChallenge(verifier))
+	if codeErr != nil {
+		t.Errorf("Error creating code: %v", codeErr)
+	}
+
+	if _, exchangeErr := db.ExchangeOAuthCode(code, client.ClientId, "https://other.example.com", verifier, SessionInfo{}, time.Hour); !errors.Is(exchangeErr, ErrInvalidGrant) {
+		t.Errorf("Expected %v for another redirect URI, got %v", ErrInvalidGrant, exchangeErr)
+	}
+
+	session, exchangeErr := db.ExchangeOAuthCode(code, client.ClientId, redirectURI, verifier, SessionInfo{}, time.Hour)
+	if exchangeErr != nil || session.ClientId != client.ClientId || session.DeviceLabel != "app" {
+		t.Errorf("Expected a session of the client, got %v, %v", session, exchangeErr)
+	}
+
+	if _, rotateErr := db.RotateSession(session.Token, "", time.Hour); !errors.Is(rotateErr, ErrSessionNotFound) {
+		t.Errorf("Expected client session to be refused without its client, got %v", rotateErr)
+	}
+
+	if _, exchangeErr := db.ExchangeOAuthCode(code, client.ClientId, redirectURI, verifier, SessionInfo{}, time.Hour); !errors.Is(exchangeErr, ErrInvalidGrant) {
+		t.Errorf("Expected %v for a reused code, got %v", ErrInvalidGrant, exchangeErr)
+	}
+
+	if sessions, _ := db.GetSessions(user.Id); len(sessions) != 0 {
+		t.Errorf("Expected code reuse to revoke the session, got %v", sessions)
+	}
+
+	// Cleanup
+
+	removeErr := os.Remove(dbPath)
+	if removeErr != nil {
+		t.Errorf("Error cleaning up: %v", removeErr)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	dbPath := "TestTwoFactor.json"
+	db, newDBErr := NewDB(dbPath)
+	if newDBErr != nil {
+		t.Errorf("Error creating DB: %v", newDBErr)
+	}
+
+	user, _ := db.CreateUser("2fa@example.com", "password")
+
+	if _, enableErr := db.EnableTOTP(user.Id, 1); !errors.Is(enableErr, ErrTOTPNotEnabled) {
+		t.Errorf("Expected %v before enrolment, got %v", ErrTOTPNotEnabled, enableErr)
+	}
+
+	if setErr := db.SetTOTPSecret(user.Id, "sealed"); setErr != nil {
+		t.Errorf("Error setting secret: %v", setErr)
+	}
+
+	codes, enableErr := db.EnableTOTP(user.Id, 100)
+	if enableErr != nil || len(codes) != recoveryCodeCount {
+		t.Errorf("Expected %d recovery codes, got %v, %v", recoveryCodeCount, codes, enableErr)
+	}
+
+	if setErr := db.SetTOTPSecret(user.Id, "other"); !errors.Is(setErr, ErrTOTPEnabled) {
+		t.Errorf("Expected %v, got %v", ErrTOTPEnabled, setErr)
+	}
+
+	if useErr := db.UseTOTPStep(user.Id, 100); !errors.Is(useErr, ErrTOTPCodeUsed) {
+		t.Errorf("Expected %v for a replayed step, got %v", ErrTOTPCodeUsed, useErr)
+	}
+
+	if useErr := db.UseTOTPStep(user.Id, 101); useErr != nil {
+		t.Errorf("Error using step: %v", useErr)
+	}
+
+	if useErr := db.UseRecoveryCode(user.Id, strings.ToUpper(codes[0])); useErr != nil {
+		t.Errorf("Error using recovery code: %v", useErr)
+	}
+
+	if useErr := db.UseRecoveryCode(user.Id, codes[0]); !errors.Is(useErr, ErrInvalidRecoveryCode) {
+		t.Errorf("Expected %v for a used recovery code, got %v", ErrInvalidRecoveryCode, useErr)
+	}
+
+	if disableErr := db.DisableTOTP(user.Id); disableErr != nil {
+		t.Errorf("Error disabling: %v", disableErr)
+	}
+
+	if disabled, _ := db.GetUser(user.Id); disabled.TOTPEnabled || len(disabled.TOTPSecret) > 0 || len(disabled.RecoveryCodeHashes) > 0 {
+		t.Errorf("Expected two-factor state to be cleared, got %v", disabled)
 	}
 
 	// Cleanup
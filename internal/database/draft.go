@@ -0,0 +1,173 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrDraftChanged = errors.New("draft changed since it was read")
+
+type Draft struct {
+	Id        int       `json:"id"`
+	AuthorId  int       `json:"author_id"`
+	Body      string    `json:"body"`
+	MediaIds  []int     `json:"media_ids,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (db *DB) CreateDraft(body string, authorId int, mediaIds []int) (Draft, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	_, getUserErr := db.getUser(authorId)
+
+	if getUserErr != nil {
+		return Draft{}, fmt.Errorf("user not found")
+	}
+
+	mediaErr := db.validateChirpMedia(authorId, mediaIds)
+
+	if mediaErr != nil {
+		return Draft{}, mediaErr
+	}
+
+	now := time.Now().UTC()
+	newDraft := Draft{
+		Id:        nextId(db.dbStructure.Drafts),
+		AuthorId:  authorId,
+		Body:      body,
+		MediaIds:  mediaIds,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	db.dbStructure.Drafts[newDraft.Id] = newDraft
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Draft{}, err
+	}
+
+	return newDraft, nil
+}
+
+func (db *DB) UpdateDraft(id, authorId int, body string, mediaIds []int) (Draft, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	draft, getErr := db.getDraft(id, authorId)
+
+	if getErr != nil {
+		return Draft{}, getErr
+	}
+
+	mediaErr := db.validateChirpMedia(authorId, mediaIds)
+
+	if mediaErr != nil {
+		return Draft{}, mediaErr
+	}
+
+	draft.Body = body
+	draft.MediaIds = mediaIds
+	draft.UpdatedAt = time.Now().UTC()
+
+	db.dbStructure.Drafts[id] = draft
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Draft{}, err
+	}
+
+	return draft, nil
+}
+
+func (db *DB) DeleteDraft(id, authorId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	_, getErr := db.getDraft(id, authorId)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	delete(db.dbStructure.Drafts, id)
+
+	return db.writeDB(db.dbStructure)
+}
+
+func (db *DB) GetDrafts(authorId int) ([]Draft, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	drafts := make([]Draft, 0)
+
+	for _, draft := range db.dbStructure.Drafts {
+		if draft.AuthorId == authorId {
+			drafts = append(drafts, draft)
+		}
+	}
+
+	return drafts, nil
+}
+
+func (db *DB) GetDraft(id, authorId int) (Draft, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.getDraft(id, authorId)
+}
+
+// getDraft returns the draft only if it belongs to authorId, so other users'
+// drafts look the same as missing ones.
+func (db *DB) getDraft(id, authorId int) (Draft, error) {
+	draft, ok := db.dbStructure.Drafts[id]
+
+	if !ok || draft.AuthorId != authorId {
+		return Draft{}, fmt.Errorf("there's no draft of %d", id)
+	}
+
+	return draft, nil
+}
+
+// PublishDraft turns a draft into a new chirp and removes the draft in a
+// single write. body is the final chirp text, which callers may have
+// cleaned up from the draft they read. updatedAt is that draft's UpdatedAt;
+// if the draft was edited since, ErrDraftChanged is returned and nothing is
+// published.
+func (db *DB) PublishDraft(id, authorId int, updatedAt time.Time, body string, options ChirpOptions) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	draft, getErr := db.getDraft(id, authorId)
+
+	if getErr != nil {
+		return Chirp{}, getErr
+	}
+
+	if !draft.UpdatedAt.Equal(updatedAt) {
+		return Chirp{}, ErrDraftChanged
+	}
+
+	options.MediaIds = draft.MediaIds
+
+	newChirp, addErr := db.addChirp(body, authorId, options)
+
+	if addErr != nil {
+		return Chirp{}, addErr
+	}
+
+	delete(db.dbStructure.Drafts, id)
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return db.viewChirp(newChirp, authorId), nil
+}
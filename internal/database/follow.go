@@ -0,0 +1,97 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+type Follow struct {
+	Id         int       `json:"id"`
+	FollowerId int       `json:"follower_id"`
+	FolloweeId int       `json:"followee_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// FollowUser makes followerId follow followeeId and notifies the followee.
+// Following someone twice is a no-op.
+func (db *DB) FollowUser(followerId, followeeId int) (Follow, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if followerId == followeeId {
+		return Follow{}, fmt.Errorf("users cannot follow themselves")
+	}
+
+	if _, getErr := db.getUser(followerId); getErr != nil {
+		return Follow{}, getErr
+	}
+
+	if _, getErr := db.getUser(followeeId); getErr != nil {
+		return Follow{}, getErr
+	}
+
+	if db.isBlocked(followerId, followeeId) {
+		return Follow{}, ErrBlocked
+	}
+
+	if existing, ok := db.getFollow(followerId, followeeId); ok {
+		return existing, nil
+	}
+
+	newFollow := Follow{
+		Id:         nextId(db.dbStructure.Follows),
+		FollowerId: followerId,
+		FolloweeId: followeeId,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	db.dbStructure.Follows[newFollow.Id] = newFollow
+
+	_, notifyErr := db.addNotification(followeeId, followerId, NotificationFollow, 0)
+
+	if notifyErr != nil {
+		return Follow{}, notifyErr
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Follow{}, err
+	}
+
+	return newFollow, nil
+}
+
+func (db *DB) UnfollowUser(followerId, followeeId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	existing, ok := db.getFollow(followerId, followeeId)
+
+	if !ok {
+		return fmt.Errorf("follow not found")
+	}
+
+	delete(db.dbStructure.Follows, existing.Id)
+
+	return db.writeDB(db.dbStructure)
+}
+
+func (db *DB) IsFollowing(followerId, followeeId int) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	_, ok := db.getFollow(followerId, followeeId)
+
+	return ok
+}
+
+func (db *DB) getFollow(followerId, followeeId int) (Follow, bool) {
+	for _, follow := range db.dbStructure.Follows {
+		if follow.FollowerId == followerId && follow.FolloweeId == followeeId {
+			return follow, true
+		}
+	}
+
+	return Follow{}, false
+}
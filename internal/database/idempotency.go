@@ -0,0 +1,135 @@
+package database
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrIdempotencyMismatch   = errors.New("idempotency key reused with a different request")
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is in progress")
+)
+
+// IdempotencyRecord holds the first response sent for an idempotency key so
+// retries can be answered with it. Records without a StatusCode belong to a
+// request that is still running.
+type IdempotencyRecord struct {
+	RequestHash string    `json:"request_hash"`
+	StatusCode  int       `json:"status_code"`
+	Body        []byte    `json:"body"`
+	ContentType string    `json:"content_type"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// SessionId is set instead of Body for logins, whose responses carry
+	// tokens. Retries get new tokens for the same session.
+	SessionId int `json:"session_id,omitempty"`
+}
+
+// BeginIdempotentRequest reserves key for a request with the given hash.
+// When the key was already used it returns the stored record and true, or
+// ErrIdempotencyMismatch if the request differs, or ErrIdempotencyInProgress
+// if the first request has not finished yet.
+func (db *DB) BeginIdempotentRequest(key, requestHash string, ttl time.Duration) (IdempotencyRecord, bool, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	now := time.Now().UTC()
+	record, ok := db.dbStructure.IdempotencyKeys[key]
+
+	if ok && record.ExpiresAt.After(now) {
+		if record.RequestHash != requestHash {
+			return IdempotencyRecord{}, false, ErrIdempotencyMismatch
+		}
+
+		if record.StatusCode == 0 {
+			return IdempotencyRecord{}, false, ErrIdempotencyInProgress
+		}
+
+		return record, true, nil
+	}
+
+	db.dbStructure.IdempotencyKeys[key] = IdempotencyRecord{
+		RequestHash: requestHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return IdempotencyRecord{}, false, err
+	}
+
+	return IdempotencyRecord{}, false, nil
+}
+
+// CompleteIdempotentRequest stores the response of a request reserved with
+// BeginIdempotentRequest.
+func (db *DB) CompleteIdempotentRequest(key string, statusCode int, contentType string, body []byte) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	record, ok := db.dbStructure.IdempotencyKeys[key]
+
+	if !ok {
+		return errors.New("idempotency key not found")
+	}
+
+	record.StatusCode = statusCode
+	record.ContentType = contentType
+	record.Body = body
+	db.dbStructure.IdempotencyKeys[key] = record
+
+	return db.writeDB(db.dbStructure)
+}
+
+// CompleteIdempotentLogin stores the session created by a login reserved
+// with BeginIdempotentRequest, in place of its response.
+func (db *DB) CompleteIdempotentLogin(key string, statusCode, sessionId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	record, ok := db.dbStructure.IdempotencyKeys[key]
+
+	if !ok {
+		return errors.New("idempotency key not found")
+	}
+
+	record.StatusCode = statusCode
+	record.SessionId = sessionId
+	db.dbStructure.IdempotencyKeys[key] = record
+
+	return db.writeDB(db.dbStructure)
+}
+
+// AbortIdempotentRequest releases a reserved key so the request can be
+// retried, e.g. after a server error.
+func (db *DB) AbortIdempotentRequest(key string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	delete(db.dbStructure.IdempotencyKeys, key)
+
+	return db.writeDB(db.dbStructure)
+}
+
+// PurgeExpiredIdempotencyKeys drops records that expired before now.
+func (db *DB) PurgeExpiredIdempotencyKeys(now time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	purged := 0
+
+	for key, record := range db.dbStructure.IdempotencyKeys {
+		if !record.ExpiresAt.After(now) {
+			delete(db.dbStructure.IdempotencyKeys, key)
+			purged++
+		}
+	}
+
+	if purged == 0 {
+		return 0, nil
+	}
+
+	return purged, db.writeDB(db.dbStructure)
+}
@@ -0,0 +1,133 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const MaxChirpMedia = 4
+
+var ErrQuotaExceeded = errors.New("media quota exceeded")
+
+type Media struct {
+	Id            int       `json:"id"`
+	OwnerId       int       `json:"owner_id"`
+	Hash          string    `json:"hash"`
+	ContentType   string    `json:"content_type"`
+	Size          int64     `json:"size"`
+	Width         int       `json:"width"`
+	Height        int       `json:"height"`
+	Path          string    `json:"path"`
+	ThumbnailPath string    `json:"thumbnail_path"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// CreateMedia records an upload for ownerId. Uploading content the owner has
+// already uploaded returns the existing record without using more quota.
+func (db *DB) CreateMedia(ownerId int, media Media, quota int64) (Media, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, ok := db.dbStructure.Users[ownerId]; !ok {
+		return Media{}, fmt.Errorf("user not found")
+	}
+
+	usage := int64(0)
+
+	for _, existing := range db.dbStructure.Media {
+		if existing.OwnerId != ownerId {
+			continue
+		}
+		if existing.Hash == media.Hash {
+			return existing, nil
+		}
+		usage += existing.Size
+	}
+
+	if quota > 0 && usage+media.Size > quota {
+		return Media{}, ErrQuotaExceeded
+	}
+
+	media.Id = nextId(db.dbStructure.Media)
+	media.OwnerId = ownerId
+	media.CreatedAt = time.Now().UTC()
+
+	db.dbStructure.Media[media.Id] = media
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Media{}, err
+	}
+
+	return media, nil
+}
+
+func (db *DB) GetMedia(id int) (Media, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	media, ok := db.dbStructure.Media[id]
+
+	if !ok {
+		return Media{}, fmt.Errorf("there's no media of %d", id)
+	}
+
+	return media, nil
+}
+
+// GetMediaByHash returns the upload of ownerId with the given content hash.
+func (db *DB) GetMediaByHash(ownerId int, hash string) (Media, bool) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	for _, media := range db.dbStructure.Media {
+		if media.OwnerId == ownerId && media.Hash == hash {
+			return media, true
+		}
+	}
+
+	return Media{}, false
+}
+
+func (db *DB) GetMediaUsage(ownerId int) int64 {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	usage := int64(0)
+
+	for _, media := range db.dbStructure.Media {
+		if media.OwnerId == ownerId {
+			usage += media.Size
+		}
+	}
+
+	return usage
+}
+
+// validateChirpMedia checks that a chirp attaches at most MaxChirpMedia
+// distinct uploads, all owned by its author.
+func (db *DB) validateChirpMedia(authorId int, mediaIds []int) error {
+	if len(mediaIds) > MaxChirpMedia {
+		return fmt.Errorf("%w: a chirp can carry at most %d media", ErrInvalidChirp, MaxChirpMedia)
+	}
+
+	seen := map[int]bool{}
+
+	for _, id := range mediaIds {
+		media, ok := db.dbStructure.Media[id]
+
+		if !ok || media.OwnerId != authorId {
+			return fmt.Errorf("%w: there's no media of %d", ErrInvalidChirp, id)
+		}
+
+		if seen[id] {
+			return fmt.Errorf("%w: media %d attached twice", ErrInvalidChirp, id)
+		}
+
+		seen[id] = true
+	}
+
+	return nil
+}
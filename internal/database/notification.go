@@ -0,0 +1,149 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	NotificationMention = "mention"
+	NotificationFollow  = "follow"
+)
+
+type Notification struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	Type      string    `json:"type"`
+	ActorId   int       `json:"actor_id"`
+	ChirpId   int       `json:"chirp_id,omitempty"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (db *DB) CreateNotification(userId, actorId int, notificationType string, chirpId int) (Notification, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	newNotification, addErr := db.addNotification(userId, actorId, notificationType, chirpId)
+
+	if addErr != nil {
+		return Notification{}, addErr
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Notification{}, err
+	}
+
+	return newNotification, nil
+}
+
+// addNotification stores a notification in memory without persisting it, so
+// callers can batch it with the change that triggered it.
+func (db *DB) addNotification(userId, actorId int, notificationType string, chirpId int) (Notification, error) {
+	switch notificationType {
+	case NotificationMention, NotificationFollow:
+	default:
+		return Notification{}, fmt.Errorf("unknown notification type %s", notificationType)
+	}
+
+	if _, ok := db.dbStructure.Users[userId]; !ok {
+		return Notification{}, fmt.Errorf("user not found")
+	}
+
+	newNotification := Notification{
+		Id:        nextId(db.dbStructure.Notifications),
+		UserId:    userId,
+		Type:      notificationType,
+		ActorId:   actorId,
+		ChirpId:   chirpId,
+		Read:      false,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	db.dbStructure.Notifications[newNotification.Id] = newNotification
+
+	return newNotification, nil
+}
+
+// GetNotifications returns one page of a user's inbox, newest first, along
+// with the total number of matching notifications.
+func (db *DB) GetNotifications(userId int, unreadOnly bool, offset, limit int) ([]Notification, int, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	notifications := make([]Notification, 0)
+
+	for _, notification := range db.dbStructure.Notifications {
+		if notification.UserId != userId {
+			continue
+		}
+		if unreadOnly && notification.Read {
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].Id > notifications[j].Id
+	})
+
+	total := len(notifications)
+
+	return paginate(notifications, offset, limit), total, nil
+}
+
+func (db *DB) CountUnreadNotifications(userId int) int {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	count := 0
+
+	for _, notification := range db.dbStructure.Notifications {
+		if notification.UserId == userId && !notification.Read {
+			count++
+		}
+	}
+
+	return count
+}
+
+// MarkNotificationsRead marks the given notifications of a user as read. An
+// empty id list marks the whole inbox as read.
+func (db *DB) MarkNotificationsRead(userId int, ids []int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if len(ids) == 0 {
+		for id, notification := range db.dbStructure.Notifications {
+			if notification.UserId == userId {
+				notification.Read = true
+				db.dbStructure.Notifications[id] = notification
+			}
+		}
+	} else {
+		for _, id := range ids {
+			notification, ok := db.dbStructure.Notifications[id]
+
+			if !ok || notification.UserId != userId {
+				return fmt.Errorf("notification not found")
+			}
+		}
+
+		for _, id := range ids {
+			notification := db.dbStructure.Notifications[id]
+			notification.Read = true
+			db.dbStructure.Notifications[id] = notification
+		}
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
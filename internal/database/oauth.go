@@ -0,0 +1,330 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// oauthCodeTTL is how long an authorization code can be exchanged.
+const oauthCodeTTL = 10 * time.Minute
+
+var (
+	ErrInvalidOAuthClient = errors.New("invalid OAuth client")
+	ErrInvalidGrant       = errors.New("invalid authorization grant")
+)
+
+// OAuthClient is a third-party app registered to act on behalf of users.
+// Confidential clients authenticate with a secret, of which only a hash is
+// stored. Public clients, such as mobile apps, rely on PKCE alone.
+type OAuthClient struct {
+	Id           int       `json:"id"`
+	ClientId     string    `json:"client_id"`
+	Secret       string    `json:"-"`
+	SecretHash   string    `json:"secret_hash,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	OwnerId      int       `json:"owner_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (client OAuthClient) Confidential() bool {
+	return len(client.SecretHash) > 0
+}
+
+// OAuthCode is an authorization code waiting to be exchanged for tokens.
+// SessionId is set once it has been exchanged.
+type OAuthCode struct {
+	Id            int       `json:"id"`
+	CodeHash      string    `json:"code_hash"`
+	ClientId      string    `json:"client_id"`
+	UserId        int       `json:"user_id"`
+	RedirectURI   string    `json:"redirect_uri"`
+	Scopes        []string  `json:"scopes"`
+	CodeChallenge string    `json:"code_challenge"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	SessionId     int       `json:"session_id,omitempty"`
+}
+
+// CreateOAuthClient registers a client owned by ownerId. The secret of a
+// confidential client is only returned here.
+func (db *DB) CreateOAuthClient(ownerId int, name string, redirectURIs []string, confidential bool) (OAuthClient, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, ok := db.dbStructure.Users[ownerId]; !ok {
+		return OAuthClient{}, fmt.Errorf("user not found")
+	}
+
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 || utf8.RuneCountInString(name) > 100 {
+		return OAuthClient{}, fmt.Errorf("%w: name must be 1 to 100 characters", ErrInvalidOAuthClient)
+	}
+
+	if len(redirectURIs) == 0 {
+		return OAuthClient{}, fmt.Errorf("%w: at least one redirect URI is required", ErrInvalidOAuthClient)
+	}
+
+	for _, redirectURI := range redirectURIs {
+		parsed, parseErr := url.Parse(redirectURI)
+
+		if parseErr != nil || !parsed.IsAbs() || len(parsed.Fragment) > 0 {
+			return OAuthClient{}, fmt.Errorf("%w: invalid redirect URI %q", ErrInvalidOAuthClient, redirectURI)
+		}
+	}
+
+	clientId, idErr := newRefreshToken()
+
+	if idErr != nil {
+		return OAuthClient{}, idErr
+	}
+
+	client := OAuthClient{
+		Id:           nextId(db.dbStructure.OAuthClients),
+		ClientId:     clientId[:32],
+		Name:         name,
+		RedirectURIs: redirectURIs,
+		OwnerId:      ownerId,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	secret := ""
+
+	if confidential {
+		var secretErr error
+		secret, secretErr = newRefreshToken()
+
+		if secretErr != nil {
+			return OAuthClient{}, secretErr
+		}
+
+		client.SecretHash = hashToken(secret)
+	}
+
+	db.dbStructure.OAuthClients[client.Id] = client
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	client.Secret = secret
+
+	return client, nil
+}
+
+func (db *DB) GetOAuthClient(clientId string) (OAuthClient, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.getOAuthClient(clientId)
+}
+
+func (db *DB) getOAuthClient(clientId string) (OAuthClient, error) {
+	for _, client := range db.dbStructure.OAuthClients {
+		if client.ClientId == clientId {
+			return client, nil
+		}
+	}
+
+	return OAuthClient{}, ErrInvalidOAuthClient
+}
+
+// AuthenticateOAuthClient checks the secret of a confidential client.
+// Public clients must not send one.
+func (db *DB) AuthenticateOAuthClient(clientId, secret string) (OAuthClient, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	client, getErr := db.getOAuthClient(clientId)
+
+	if getErr != nil {
+		return OAuthClient{}, getErr
+	}
+
+	if client.Confidential() != (len(secret) > 0) {
+		return OAuthClient{}, ErrInvalidOAuthClient
+	}
+
+	if client.Confidential() && !equalHashes(client.SecretHash, hashToken(secret)) {
+		return OAuthClient{}, ErrInvalidOAuthClient
+	}
+
+	return client, nil
+}
+
+// CreateOAuthCode records the consent of userId to grant scopes to a
+// client and returns the authorization code to send back to it.
+// codeChallenge is the S256 PKCE challenge the token request must match.
+func (db *DB) CreateOAuthCode(clientId string, userId int, redirectURI string, scopes []string, codeChallenge string) (string, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	client, getErr := db.getOAuthClient(clientId)
+
+	if getErr != nil {
+		return "", getErr
+	}
+
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		return "", fmt.Errorf("%w: redirect URI not registered", ErrInvalidOAuthClient)
+	}
+
+	code, codeErr := newRefreshToken()
+
+	if codeErr != nil {
+		return "", codeErr
+	}
+
+	newCode := OAuthCode{
+		Id:            nextId(db.dbStructure.OAuthCodes),
+		CodeHash:      hashToken(code),
+		ClientId:      clientId,
+		UserId:        userId,
+		RedirectURI:   redirectURI,
+		Scopes:        scopes,
+		CodeChallenge: codeChallenge,
+		ExpiresAt:     time.Now().UTC().Add(oauthCodeTTL),
+	}
+
+	db.dbStructure.OAuthCodes[newCode.Id] = newCode
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ExchangeOAuthCode redeems an authorization code for a session of the
+// client, checking the redirect URI and the PKCE verifier. A code can be
+// redeemed once. Presenting it again revokes the session it created, as
+// the code must have leaked.
+func (db *DB) ExchangeOAuthCode(code, clientId, redirectURI, codeVerifier string, info SessionInfo, ttl time.Duration) (Session, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	hash := hashToken(code)
+
+	for id, grant := range db.dbStructure.OAuthCodes {
+		if !equalHashes(grant.CodeHash, hash) {
+			continue
+		}
+
+		if grant.SessionId != 0 {
+			delete(db.dbStructure.Sessions, grant.SessionId)
+			delete(db.dbStructure.OAuthCodes, id)
+
+			err := db.writeDB(db.dbStructure)
+
+			if err != nil {
+				return Session{}, err
+			}
+
+			return Session{}, fmt.Errorf("%w: code already used", ErrInvalidGrant)
+		}
+
+		if !grant.ExpiresAt.After(time.Now()) || grant.ClientId != clientId || grant.RedirectURI != redirectURI {
+			return Session{}, ErrInvalidGrant
+		}
+
+		if !equalHashes(grant.CodeChallenge, PKCEChallenge(codeVerifier)) {
+			return Session{}, fmt.Errorf("%w: code verifier does not match", ErrInvalidGrant)
+		}
+
+		client, getErr := db.getOAuthClient(clientId)
+
+		if getErr != nil {
+			return Session{}, getErr
+		}
+
+		if user, ok := db.dbStructure.Users[grant.UserId]; ok && user.Suspended {
+			return Session{}, ErrSuspended
+		}
+
+		info.DeviceLabel = client.Name
+
+		session, addErr := db.addSession(grant.UserId, info, ttl)
+
+		if addErr != nil {
+			return Session{}, addErr
+		}
+
+		session.ClientId = clientId
+		session.Scopes = grant.Scopes
+		stored := db.dbStructure.Sessions[session.Id]
+		stored.ClientId = clientId
+		stored.Scopes = grant.Scopes
+		db.dbStructure.Sessions[session.Id] = stored
+
+		grant.SessionId = session.Id
+		db.dbStructure.OAuthCodes[id] = grant
+
+		err := db.writeDB(db.dbStructure)
+
+		if err != nil {
+			return Session{}, err
+		}
+
+		return session, nil
+	}
+
+	return Session{}, ErrInvalidGrant
+}
+
+// DeleteClientSessionByToken revokes a session of clientId by its refresh
+// token. Tokens of other clients are ignored.
+func (db *DB) DeleteClientSessionByToken(token, clientId string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	hash := hashToken(token)
+
+	for id, session := range db.dbStructure.Sessions {
+		if session.ClientId == clientId && equalHashes(session.TokenHash, hash) {
+			delete(db.dbStructure.Sessions, id)
+			return db.writeDB(db.dbStructure)
+		}
+	}
+
+	return nil
+}
+
+// PurgeExpiredOAuthCodes drops authorization codes that can no longer be
+// exchanged. Redeemed codes are kept until then to detect replays.
+func (db *DB) PurgeExpiredOAuthCodes(now time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	purged := 0
+
+	for id, grant := range db.dbStructure.OAuthCodes {
+		if !grant.ExpiresAt.After(now) {
+			delete(db.dbStructure.OAuthCodes, id)
+			purged++
+		}
+	}
+
+	if purged == 0 {
+		return 0, nil
+	}
+
+	return purged, db.writeDB(db.dbStructure)
+}
+
+// PKCEChallenge derives the S256 code challenge of a PKCE code verifier
+// (RFC 7636).
+func PKCEChallenge(codeVerifier string) string {
+	sum := sha256.Sum256([]byte(codeVerifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
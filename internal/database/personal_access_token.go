@@ -0,0 +1,195 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// PersonalAccessTokenPrefix starts every personal access token, so they can
+// be told apart from JWTs and spotted by secret scanners.
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+// lastUsedPrecision limits how often using a token is written to disk.
+const lastUsedPrecision = time.Minute
+
+var (
+	ErrAccessTokenNotFound = errors.New("personal access token not found")
+	ErrInvalidAccessToken  = errors.New("invalid personal access token")
+)
+
+// PersonalAccessToken is a long-lived credential limited to a set of
+// scopes, for bots and integrations. Like sessions, only a hash of the token
+// is stored and Token is set only when it is created.
+type PersonalAccessToken struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Token      string     `json:"-"`
+	TokenHash  string     `json:"token_hash"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// CreatePersonalAccessToken issues a token for userId. A nil expiresAt
+// makes a token that is valid until deleted.
+func (db *DB) CreatePersonalAccessToken(userId int, name string, scopes []string, expiresAt *time.Time) (PersonalAccessToken, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if _, ok := db.dbStructure.Users[userId]; !ok {
+		return PersonalAccessToken{}, fmt.Errorf("user not found")
+	}
+
+	name = strings.TrimSpace(name)
+
+	if len(name) == 0 || utf8.RuneCountInString(name) > 100 {
+		return PersonalAccessToken{}, fmt.Errorf("%w: name must be 1 to 100 characters", ErrInvalidAccessToken)
+	}
+
+	if len(scopes) == 0 {
+		return PersonalAccessToken{}, fmt.Errorf("%w: at least one scope is required", ErrInvalidAccessToken)
+	}
+
+	now := time.Now().UTC()
+
+	if expiresAt != nil && !expiresAt.After(now) {
+		return PersonalAccessToken{}, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidAccessToken)
+	}
+
+	random, tokenErr := newRefreshToken()
+
+	if tokenErr != nil {
+		return PersonalAccessToken{}, tokenErr
+	}
+
+	token := PersonalAccessTokenPrefix + random
+
+	pat := PersonalAccessToken{
+		Id:        nextId(db.dbStructure.PersonalAccessTokens),
+		UserId:    userId,
+		Name:      name,
+		Scopes:    scopes,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+
+	db.dbStructure.PersonalAccessTokens[pat.Id] = pat
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+
+	pat.Token = token
+
+	return pat, nil
+}
+
+// LookupPersonalAccessToken returns the unexpired token matching token.
+func (db *DB) LookupPersonalAccessToken(token string) (PersonalAccessToken, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.lookupPersonalAccessToken(token, time.Now().UTC())
+}
+
+// UsePersonalAccessToken is LookupPersonalAccessToken that also records
+// when the token was last used, to the minute.
+func (db *DB) UsePersonalAccessToken(token string) (PersonalAccessToken, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	now := time.Now().UTC()
+	pat, lookupErr := db.lookupPersonalAccessToken(token, now)
+
+	if lookupErr != nil {
+		return PersonalAccessToken{}, lookupErr
+	}
+
+	if pat.LastUsedAt != nil && now.Sub(*pat.LastUsedAt) < lastUsedPrecision {
+		return pat, nil
+	}
+
+	pat.LastUsedAt = &now
+	db.dbStructure.PersonalAccessTokens[pat.Id] = pat
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return PersonalAccessToken{}, err
+	}
+
+	return pat, nil
+}
+
+func (db *DB) lookupPersonalAccessToken(token string, now time.Time) (PersonalAccessToken, error) {
+	hash := hashToken(token)
+
+	for _, pat := range db.dbStructure.PersonalAccessTokens {
+		if !equalHashes(pat.TokenHash, hash) {
+			continue
+		}
+
+		if pat.ExpiresAt != nil && !pat.ExpiresAt.After(now) {
+			return PersonalAccessToken{}, ErrAccessTokenNotFound
+		}
+
+		return pat, nil
+	}
+
+	return PersonalAccessToken{}, ErrAccessTokenNotFound
+}
+
+// GetPersonalAccessTokens returns a user's tokens, newest first, including
+// expired ones so they can be cleaned up.
+func (db *DB) GetPersonalAccessTokens(userId int) ([]PersonalAccessToken, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	pats := make([]PersonalAccessToken, 0)
+
+	for _, pat := range db.dbStructure.PersonalAccessTokens {
+		if pat.UserId == userId {
+			pats = append(pats, pat)
+		}
+	}
+
+	sort.Slice(pats, func(i, j int) bool {
+		return pats[i].Id > pats[j].Id
+	})
+
+	return pats, nil
+}
+
+func (db *DB) DeletePersonalAccessToken(userId, id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	pat, ok := db.dbStructure.PersonalAccessTokens[id]
+
+	if !ok || pat.UserId != userId {
+		return ErrAccessTokenNotFound
+	}
+
+	delete(db.dbStructure.PersonalAccessTokens, id)
+
+	return db.writeDB(db.dbStructure)
+}
+
+// deleteUserPersonalAccessTokens drops every token of a user without
+// persisting.
+func (db *DB) deleteUserPersonalAccessTokens(userId int) {
+	for id, pat := range db.dbStructure.PersonalAccessTokens {
+		if pat.UserId == userId {
+			delete(db.dbStructure.PersonalAccessTokens, id)
+		}
+	}
+}
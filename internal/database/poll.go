@@ -0,0 +1,150 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	MinPollOptions      = 2
+	MaxPollOptions      = 4
+	maxPollOptionLength = 25
+)
+
+var (
+	ErrPollClosed   = errors.New("poll is closed")
+	ErrAlreadyVoted = errors.New("already voted")
+	ErrInvalidVote  = errors.New("invalid vote")
+)
+
+// Poll is attached to a chirp. Tallies and VotedOption are never stored; they
+// are filled in per viewer on read once the viewer may see the results.
+type Poll struct {
+	Options     []string  `json:"options"`
+	ClosesAt    time.Time `json:"closes_at"`
+	Closed      bool      `json:"closed"`
+	Tallies     []int     `json:"tallies,omitempty"`
+	VotedOption *int      `json:"voted_option,omitempty"`
+}
+
+type Vote struct {
+	Id        int       `json:"id"`
+	ChirpId   int       `json:"chirp_id"`
+	UserId    int       `json:"user_id"`
+	Option    int       `json:"option"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// newPoll validates the options and closing time of a poll for a chirp
+// published at publishAt.
+func newPoll(options []string, closesAt time.Time, publishAt time.Time) (*Poll, error) {
+	if len(options) < MinPollOptions || len(options) > MaxPollOptions {
+		return nil, fmt.Errorf("%w: a poll needs %d-%d options", ErrInvalidChirp, MinPollOptions, MaxPollOptions)
+	}
+
+	cleaned := make([]string, 0, len(options))
+
+	for _, option := range options {
+		option = strings.TrimSpace(option)
+
+		if len(option) == 0 || utf8.RuneCountInString(option) > maxPollOptionLength {
+			return nil, fmt.Errorf("%w: poll options must be 1-%d characters", ErrInvalidChirp, maxPollOptionLength)
+		}
+
+		cleaned = append(cleaned, option)
+	}
+
+	if !closesAt.After(publishAt) {
+		return nil, fmt.Errorf("%w: a poll must close after it is published", ErrInvalidChirp)
+	}
+
+	return &Poll{Options: cleaned, ClosesAt: closesAt.UTC()}, nil
+}
+
+// Vote records userId's choice in the poll of a chirp and returns the chirp
+// with the results visible.
+func (db *DB) Vote(chirpId, userId, option int) (Chirp, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	chirp, ok := db.dbStructure.Chirps[chirpId]
+
+	if !ok || !db.canView(chirp, userId) || chirp.Pending {
+		return Chirp{}, fmt.Errorf("there's no chirp of %d", chirpId)
+	}
+
+	if chirp.Poll == nil {
+		return Chirp{}, fmt.Errorf("%w: chirp has no poll", ErrInvalidVote)
+	}
+
+	if !time.Now().Before(chirp.Poll.ClosesAt) {
+		return Chirp{}, ErrPollClosed
+	}
+
+	if option < 0 || option >= len(chirp.Poll.Options) {
+		return Chirp{}, fmt.Errorf("%w: no option %d", ErrInvalidVote, option)
+	}
+
+	if _, voted := db.getVote(chirpId, userId); voted {
+		return Chirp{}, ErrAlreadyVoted
+	}
+
+	newVote := Vote{
+		Id:        nextId(db.dbStructure.Votes),
+		ChirpId:   chirpId,
+		UserId:    userId,
+		Option:    option,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	db.dbStructure.Votes[newVote.Id] = newVote
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Chirp{}, err
+	}
+
+	return db.viewChirp(chirp, userId), nil
+}
+
+func (db *DB) getVote(chirpId, userId int) (Vote, bool) {
+	for _, vote := range db.dbStructure.Votes {
+		if vote.ChirpId == chirpId && vote.UserId == userId {
+			return vote, true
+		}
+	}
+
+	return Vote{}, false
+}
+
+// viewPoll returns a copy of poll as viewerId may see it: tallies are only
+// included once the viewer has voted or the poll has closed.
+func (db *DB) viewPoll(chirpId int, poll Poll, viewerId int) *Poll {
+	poll.Closed = !time.Now().Before(poll.ClosesAt)
+	poll.Tallies = nil
+	poll.VotedOption = nil
+
+	vote, voted := db.getVote(chirpId, viewerId)
+
+	if voted && viewerId != 0 {
+		poll.VotedOption = &vote.Option
+	}
+
+	if !poll.Closed && poll.VotedOption == nil {
+		return &poll
+	}
+
+	poll.Tallies = make([]int, len(poll.Options))
+
+	for _, vote := range db.dbStructure.Votes {
+		if vote.ChirpId == chirpId && vote.Option < len(poll.Tallies) {
+			poll.Tallies[vote.Option]++
+		}
+	}
+
+	return &poll
+}
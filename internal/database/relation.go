@@ -0,0 +1,142 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	RelationBlock = "block"
+	RelationMute  = "mute"
+)
+
+var ErrBlocked = errors.New("blocked")
+
+// Relation is a one-way block or mute placed by UserId on TargetId.
+type Relation struct {
+	Id        int       `json:"id"`
+	UserId    int       `json:"user_id"`
+	TargetId  int       `json:"target_id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateRelation blocks or mutes targetId for userId. Blocking also removes
+// any follow between the two users in either direction.
+func (db *DB) CreateRelation(userId, targetId int, kind string) (Relation, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	if kind != RelationBlock && kind != RelationMute {
+		return Relation{}, fmt.Errorf("unknown relation %s", kind)
+	}
+
+	if userId == targetId {
+		return Relation{}, fmt.Errorf("users cannot %s themselves", kind)
+	}
+
+	if _, getErr := db.getUser(targetId); getErr != nil {
+		return Relation{}, getErr
+	}
+
+	if existing, ok := db.getRelation(userId, targetId, kind); ok {
+		return existing, nil
+	}
+
+	newRelation := Relation{
+		Id:        nextId(db.dbStructure.Relations),
+		UserId:    userId,
+		TargetId:  targetId,
+		Kind:      kind,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	db.dbStructure.Relations[newRelation.Id] = newRelation
+
+	if kind == RelationBlock {
+		for id, follow := range db.dbStructure.Follows {
+			if (follow.FollowerId == userId && follow.FolloweeId == targetId) ||
+				(follow.FollowerId == targetId && follow.FolloweeId == userId) {
+				delete(db.dbStructure.Follows, id)
+			}
+		}
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Relation{}, err
+	}
+
+	return newRelation, nil
+}
+
+func (db *DB) DeleteRelation(userId, targetId int, kind string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	existing, ok := db.getRelation(userId, targetId, kind)
+
+	if !ok {
+		return fmt.Errorf("%s not found", kind)
+	}
+
+	delete(db.dbStructure.Relations, existing.Id)
+
+	return db.writeDB(db.dbStructure)
+}
+
+// GetRelations lists the users userId has blocked or muted.
+func (db *DB) GetRelations(userId int, kind string) ([]Relation, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	relations := make([]Relation, 0)
+
+	for _, relation := range db.dbStructure.Relations {
+		if relation.UserId == userId && relation.Kind == kind {
+			relations = append(relations, relation)
+		}
+	}
+
+	return relations, nil
+}
+
+func (db *DB) getRelation(userId, targetId int, kind string) (Relation, bool) {
+	for _, relation := range db.dbStructure.Relations {
+		if relation.UserId == userId && relation.TargetId == targetId && relation.Kind == kind {
+			return relation, true
+		}
+	}
+
+	return Relation{}, false
+}
+
+// isBlocked reports whether either user has blocked the other. Any
+// interaction between two users, such as a mention or a follow,
+// must check it.
+func (db *DB) isBlocked(userId, otherId int) bool {
+	if userId == 0 || otherId == 0 {
+		return false
+	}
+
+	_, blocked := db.getRelation(userId, otherId, RelationBlock)
+	if blocked {
+		return true
+	}
+
+	_, blockedBy := db.getRelation(otherId, userId, RelationBlock)
+
+	return blockedBy
+}
+
+func (db *DB) isMuted(userId, targetId int) bool {
+	if userId == 0 {
+		return false
+	}
+
+	_, muted := db.getRelation(userId, targetId, RelationMute)
+
+	return muted
+}
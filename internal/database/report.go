@@ -0,0 +1,226 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	ReportSpam       = "spam"
+	ReportHarassment = "harassment"
+	ReportHate       = "hate"
+	ReportViolence   = "violence"
+	ReportSexual     = "sexual"
+	ReportOther      = "other"
+)
+
+const (
+	ReportOpen   = "open"
+	ReportClosed = "closed"
+)
+
+const (
+	ModerationHideChirp   = "hide_chirp"
+	ModerationRemoveChirp = "remove_chirp"
+	ModerationSuspendUser = "suspend_user"
+	ModerationCloseReport = "close_report"
+)
+
+var (
+	ErrInvalidReport = errors.New("invalid report")
+	ErrReportClosed  = errors.New("report is closed")
+)
+
+type Report struct {
+	Id         int        `json:"id"`
+	ChirpId    int        `json:"chirp_id"`
+	AuthorId   int        `json:"author_id"`
+	ReporterId int        `json:"reporter_id"`
+	Reason     string     `json:"reason"`
+	Details    string     `json:"details,omitempty"`
+	Status     string     `json:"status"`
+	Resolution string     `json:"resolution,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ClosedAt   *time.Time `json:"closed_at,omitempty"`
+	ClosedBy   int        `json:"closed_by,omitempty"`
+}
+
+// ModerationAction is an audit record of something an admin did.
+type ModerationAction struct {
+	Id        int       `json:"id"`
+	AdminId   int       `json:"admin_id"`
+	Action    string    `json:"action"`
+	ReportId  int       `json:"report_id"`
+	ChirpId   int       `json:"chirp_id,omitempty"`
+	UserId    int       `json:"user_id,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// CreateReport flags a chirp the reporter can see. A reporter's open report
+// on the same chirp is returned instead of filing a duplicate.
+func (db *DB) CreateReport(chirpId, reporterId int, reason, details string) (Report, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	switch reason {
+	case ReportSpam, ReportHarassment, ReportHate, ReportViolence, ReportSexual, ReportOther:
+	default:
+		return Report{}, fmt.Errorf("%w: unknown reason %s", ErrInvalidReport, reason)
+	}
+
+	chirp, ok := db.dbStructure.Chirps[chirpId]
+
+	if !ok || !db.canView(chirp, reporterId) {
+		return Report{}, fmt.Errorf("there's no chirp of %d", chirpId)
+	}
+
+	for _, report := range db.dbStructure.Reports {
+		if report.ChirpId == chirpId && report.ReporterId == reporterId && report.Status == ReportOpen {
+			return report, nil
+		}
+	}
+
+	newReport := Report{
+		Id:         nextId(db.dbStructure.Reports),
+		ChirpId:    chirpId,
+		AuthorId:   chirp.AuthorId,
+		ReporterId: reporterId,
+		Reason:     reason,
+		Details:    details,
+		Status:     ReportOpen,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	db.dbStructure.Reports[newReport.Id] = newReport
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Report{}, err
+	}
+
+	return newReport, nil
+}
+
+// GetReports lists reports with the given status, oldest first, or all
+// reports when status is empty.
+func (db *DB) GetReports(status string) ([]Report, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	reports := make([]Report, 0)
+
+	for _, report := range db.dbStructure.Reports {
+		if len(status) == 0 || report.Status == status {
+			reports = append(reports, report)
+		}
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Id < reports[j].Id
+	})
+
+	return reports, nil
+}
+
+// ModerateReport applies a moderation action to an open report and records
+// who did it. Hiding or removing acts on the reported chirp, suspending acts
+// on its author, and closing stores note as the report's resolution.
+func (db *DB) ModerateReport(reportId, adminId int, action, note string) (Report, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	admin, getErr := db.getUser(adminId)
+
+	if getErr != nil || !admin.IsAdmin {
+		return Report{}, fmt.Errorf("user %d is not an admin", adminId)
+	}
+
+	report, ok := db.dbStructure.Reports[reportId]
+
+	if !ok {
+		return Report{}, fmt.Errorf("there's no report of %d", reportId)
+	}
+
+	if report.Status != ReportOpen {
+		return Report{}, ErrReportClosed
+	}
+
+	moderationAction := ModerationAction{
+		Id:        nextId(db.dbStructure.ModerationActions),
+		AdminId:   adminId,
+		Action:    action,
+		ReportId:  reportId,
+		Note:      note,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	switch action {
+	case ModerationHideChirp:
+		chirp, ok := db.dbStructure.Chirps[report.ChirpId]
+		if !ok {
+			return Report{}, fmt.Errorf("there's no chirp of %d", report.ChirpId)
+		}
+		chirp.Hidden = true
+		db.dbStructure.Chirps[chirp.Id] = chirp
+		moderationAction.ChirpId = chirp.Id
+	case ModerationRemoveChirp:
+		deleteErr := db.deleteChirp(report.ChirpId)
+		if deleteErr != nil {
+			return Report{}, deleteErr
+		}
+		moderationAction.ChirpId = report.ChirpId
+	case ModerationSuspendUser:
+		author, getErr := db.getUser(report.AuthorId)
+		if getErr != nil {
+			return Report{}, getErr
+		}
+		author.Suspended = true
+		author = db.revokeUserTokens(author)
+		db.dbStructure.Users[author.Id] = author
+		moderationAction.UserId = author.Id
+	case ModerationCloseReport:
+		if len(note) == 0 {
+			return Report{}, fmt.Errorf("%w: a resolution is required", ErrInvalidReport)
+		}
+		closedAt := time.Now().UTC()
+		report.Status = ReportClosed
+		report.Resolution = note
+		report.ClosedAt = &closedAt
+		report.ClosedBy = adminId
+		db.dbStructure.Reports[report.Id] = report
+	default:
+		return Report{}, fmt.Errorf("%w: unknown action %s", ErrInvalidReport, action)
+	}
+
+	db.dbStructure.ModerationActions[moderationAction.Id] = moderationAction
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Report{}, err
+	}
+
+	return report, nil
+}
+
+// GetModerationActions returns the moderation log, newest first.
+func (db *DB) GetModerationActions() ([]ModerationAction, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	actions := make([]ModerationAction, 0)
+
+	for _, action := range db.dbStructure.ModerationActions {
+		actions = append(actions, action)
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].Id > actions[j].Id
+	})
+
+	return actions, nil
+}
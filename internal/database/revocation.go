@@ -0,0 +1,69 @@
+package database
+
+import "time"
+
+// RevokeAccessToken denies the access token with the given jti until
+// expiresAt, after which the token is rejected as expired anyway.
+func (db *DB) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	db.dbStructure.RevokedAccessTokens[jti] = expiresAt.UTC()
+
+	return db.writeDB(db.dbStructure)
+}
+
+// IsAccessTokenRevoked reports whether an access token of userId, issued at
+// issuedAt with the given jti, may no longer be used. Tokens of deleted
+// users and tokens issued before the user's last password change are
+// revoked too.
+func (db *DB) IsAccessTokenRevoked(jti string, userId int, issuedAt time.Time) bool {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	if _, ok := db.dbStructure.RevokedAccessTokens[jti]; ok && len(jti) > 0 {
+		return true
+	}
+
+	user, ok := db.dbStructure.Users[userId]
+
+	if !ok {
+		return true
+	}
+
+	return issuedAt.Before(user.TokensValidAfter)
+}
+
+// revokeUserTokens revokes every access token, personal access token and
+// session a user holds, including those of OAuth clients, without
+// persisting. Tokens carry iat in milliseconds, so the cutoff is rounded
+// down to match.
+func (db *DB) revokeUserTokens(user User) User {
+	user.TokensValidAfter = time.Now().UTC().Truncate(time.Millisecond)
+	db.deleteUserSessions(user.Id)
+	db.deleteUserPersonalAccessTokens(user.Id)
+
+	return user
+}
+
+// PurgeExpiredRevocations drops denylist entries for tokens that expired
+// before now.
+func (db *DB) PurgeExpiredRevocations(now time.Time) (int, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	purged := 0
+
+	for jti, expiresAt := range db.dbStructure.RevokedAccessTokens {
+		if !expiresAt.After(now) {
+			delete(db.dbStructure.RevokedAccessTokens, jti)
+			purged++
+		}
+	}
+
+	if purged == 0 {
+		return 0, nil
+	}
+
+	return purged, db.writeDB(db.dbStructure)
+}
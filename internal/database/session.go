@@ -0,0 +1,382 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+)
+
+// legacySessionTTL is the lifetime given to refresh tokens migrated from
+// older database files, which didn't record an expiry.
+const legacySessionTTL = 60 * 24 * time.Hour
+
+// maxRetiredTokens bounds how many rotated-out tokens a session remembers
+// for reuse detection.
+const maxRetiredTokens = 50
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrTokenReused     = errors.New("refresh token reused")
+)
+
+// Session is a refresh token issued to one device, along with the tokens it
+// replaced on rotation. A user can hold any number of sessions and revoke
+// each of them separately.
+//
+// Only SHA-256 hashes of tokens are stored. Token holds the raw value just
+// in the Session returned when it is issued, and is never persisted.
+type Session struct {
+	Id          int       `json:"id"`
+	UserId      int       `json:"user_id"`
+	Token       string    `json:"-"`
+	TokenHash   string    `json:"token_hash"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IP          string    `json:"ip"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	// RetiredTokenHashes are the hashes of the tokens this session held
+	// before its latest rotations.
+	RetiredTokenHashes []string `json:"retired_token_hashes,omitempty"`
+	// ClientId and Scopes are set for sessions granted to OAuth clients.
+	// Such sessions can only be refreshed by their client.
+	ClientId string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+
+	// PlainToken and PlainRetiredTokens are unhashed tokens written by older
+	// versions. They are hashed on load.
+	PlainToken         string   `json:"token,omitempty"`
+	PlainRetiredTokens []string `json:"retired_tokens,omitempty"`
+}
+
+// SessionInfo describes the device a session is created for.
+type SessionInfo struct {
+	DeviceLabel string
+	UserAgent   string
+	IP          string
+}
+
+// CreateSession issues a new refresh token for userId, valid for ttl.
+func (db *DB) CreateSession(userId int, info SessionInfo, ttl time.Duration) (Session, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	session, addErr := db.addSession(userId, info, ttl)
+
+	if addErr != nil {
+		return Session{}, addErr
+	}
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Session{}, err
+	}
+
+	return session, nil
+}
+
+// addSession stores a new session in memory without persisting it. The
+// returned copy holds the raw token.
+func (db *DB) addSession(userId int, info SessionInfo, ttl time.Duration) (Session, error) {
+	if _, ok := db.dbStructure.Users[userId]; !ok {
+		return Session{}, errors.New("user not found")
+	}
+
+	token, tokenErr := newRefreshToken()
+
+	if tokenErr != nil {
+		return Session{}, tokenErr
+	}
+
+	now := time.Now().UTC()
+
+	session := Session{
+		Id:          nextId(db.dbStructure.Sessions),
+		UserId:      userId,
+		TokenHash:   hashToken(token),
+		DeviceLabel: info.DeviceLabel,
+		UserAgent:   info.UserAgent,
+		IP:          info.IP,
+		CreatedAt:   now,
+		LastUsedAt:  now,
+		ExpiresAt:   now.Add(ttl),
+	}
+
+	db.dbStructure.Sessions[session.Id] = session
+
+	session.Token = token
+
+	return session, nil
+}
+
+// RotateSession exchanges the refresh token of an unexpired session for a
+// new one valid for ttl, recording that it was used from ip. Presenting a
+// token that was already rotated out revokes the whole session, since only
+// a stolen copy would still be in use; the revoked session is returned
+// along with ErrTokenReused. Sessions of OAuth clients can't be rotated
+// here.
+func (db *DB) RotateSession(token, ip string, ttl time.Duration) (Session, error) {
+	return db.RotateClientSession(token, "", ip, ttl)
+}
+
+// RotateClientSession is RotateSession for the sessions of an OAuth client.
+func (db *DB) RotateClientSession(token, clientId, ip string, ttl time.Duration) (Session, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	now := time.Now().UTC()
+	hash := hashToken(token)
+
+	for id, session := range db.dbStructure.Sessions {
+		if containsHash(session.RetiredTokenHashes, hash) {
+			delete(db.dbStructure.Sessions, id)
+
+			err := db.writeDB(db.dbStructure)
+
+			if err != nil {
+				return Session{}, err
+			}
+
+			return session, ErrTokenReused
+		}
+
+		if !equalHashes(session.TokenHash, hash) {
+			continue
+		}
+
+		if !session.ExpiresAt.After(now) || session.ClientId != clientId {
+			return Session{}, ErrSessionNotFound
+		}
+
+		if user, ok := db.dbStructure.Users[session.UserId]; ok && user.Suspended {
+			return Session{}, ErrSuspended
+		}
+
+		newToken, tokenErr := newRefreshToken()
+
+		if tokenErr != nil {
+			return Session{}, tokenErr
+		}
+
+		session.RetiredTokenHashes = append(session.RetiredTokenHashes, session.TokenHash)
+		if len(session.RetiredTokenHashes) > maxRetiredTokens {
+			session.RetiredTokenHashes = session.RetiredTokenHashes[len(session.RetiredTokenHashes)-maxRetiredTokens:]
+		}
+
+		session.TokenHash = hashToken(newToken)
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(ttl)
+		session.IP = ip
+		db.dbStructure.Sessions[id] = session
+
+		err := db.writeDB(db.dbStructure)
+
+		if err != nil {
+			return Session{}, err
+		}
+
+		session.Token = newToken
+
+		return session, nil
+	}
+
+	return Session{}, ErrSessionNotFound
+}
+
+// ReissueSession gives an unexpired session a new refresh token valid for
+// ttl. Unlike RotateSession the old token is not kept as retired, so it
+// stops working without counting as reuse. It answers retried logins whose
+// first response was lost.
+func (db *DB) ReissueSession(id int, ttl time.Duration) (Session, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	now := time.Now().UTC()
+	session, ok := db.dbStructure.Sessions[id]
+
+	if !ok || !session.ExpiresAt.After(now) {
+		return Session{}, ErrSessionNotFound
+	}
+
+	if user, ok := db.dbStructure.Users[session.UserId]; ok && user.Suspended {
+		return Session{}, ErrSuspended
+	}
+
+	newToken, tokenErr := newRefreshToken()
+
+	if tokenErr != nil {
+		return Session{}, tokenErr
+	}
+
+	session.TokenHash = hashToken(newToken)
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(ttl)
+	db.dbStructure.Sessions[id] = session
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return Session{}, err
+	}
+
+	session.Token = newToken
+
+	return session, nil
+}
+
+// GetSessions returns the unexpired sessions of a user, most recently used
+// first.
+func (db *DB) GetSessions(userId int) ([]Session, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	now := time.Now().UTC()
+	sessions := make([]Session, 0)
+
+	for _, session := range db.dbStructure.Sessions {
+		if session.UserId == userId && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// DeleteSession revokes one of a user's sessions by id.
+func (db *DB) DeleteSession(userId, id int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	session, ok := db.dbStructure.Sessions[id]
+
+	if !ok || session.UserId != userId {
+		return ErrSessionNotFound
+	}
+
+	delete(db.dbStructure.Sessions, id)
+
+	return db.writeDB(db.dbStructure)
+}
+
+// DeleteSessionByToken revokes the session holding token and returns it.
+// Unknown tokens are ignored and yield an empty session.
+func (db *DB) DeleteSessionByToken(token string) (Session, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	hash := hashToken(token)
+
+	for id, session := range db.dbStructure.Sessions {
+		if equalHashes(session.TokenHash, hash) {
+			delete(db.dbStructure.Sessions, id)
+			return session, db.writeDB(db.dbStructure)
+		}
+	}
+
+	return Session{}, nil
+}
+
+// deleteUserSessions drops every session of a user without persisting.
+func (db *DB) deleteUserSessions(userId int) {
+	for id, session := range db.dbStructure.Sessions {
+		if session.UserId == userId {
+			delete(db.dbStructure.Sessions, id)
+		}
+	}
+}
+
+// migrateRefreshTokens turns the single refresh token per user stored by
+// older versions into sessions, so existing logins keep working. It
+// reports whether there were any to migrate.
+func (structure *DBStructure) migrateRefreshTokens(ttl time.Duration) bool {
+	if len(structure.RefreshTokens) == 0 {
+		return false
+	}
+
+	now := time.Now().UTC()
+
+	for userId, token := range structure.RefreshTokens {
+		id := nextId(structure.Sessions)
+		structure.Sessions[id] = Session{
+			Id:          id,
+			UserId:      userId,
+			TokenHash:   hashToken(token),
+			DeviceLabel: "Unknown device",
+			CreatedAt:   now,
+			LastUsedAt:  now,
+			ExpiresAt:   now.Add(ttl),
+		}
+	}
+
+	structure.RefreshTokens = nil
+
+	return true
+}
+
+// hashSessionTokens replaces the unhashed tokens of sessions written by
+// older versions with their hashes. It reports whether any were found.
+func (structure *DBStructure) hashSessionTokens() bool {
+	changed := false
+
+	for id, session := range structure.Sessions {
+		if len(session.PlainToken) == 0 && len(session.PlainRetiredTokens) == 0 {
+			continue
+		}
+
+		if len(session.PlainToken) > 0 {
+			session.TokenHash = hashToken(session.PlainToken)
+		}
+
+		for _, token := range session.PlainRetiredTokens {
+			session.RetiredTokenHashes = append(session.RetiredTokenHashes, hashToken(token))
+		}
+
+		session.PlainToken = ""
+		session.PlainRetiredTokens = nil
+		structure.Sessions[id] = session
+		changed = true
+	}
+
+	return changed
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// equalHashes compares token hashes in constant time.
+func equalHashes(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func containsHash(hashes []string, hash string) bool {
+	for _, candidate := range hashes {
+		if equalHashes(candidate, hash) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newRefreshToken() (string, error) {
+	dummyString := make([]byte, 32)
+	_, readErr := rand.Read(dummyString)
+
+	if readErr != nil {
+		return "", readErr
+	}
+
+	return hex.EncodeToString(dummyString), nil
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets on enrolment.
+const recoveryCodeCount = 10
+
+var (
+	ErrTOTPEnabled         = errors.New("two-factor authentication already enabled")
+	ErrTOTPNotEnabled      = errors.New("two-factor authentication not enabled")
+	ErrTOTPCodeUsed        = errors.New("code already used")
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+)
+
+// SetTOTPSecret starts enrolling a user in two-factor authentication with
+// an encrypted secret. It takes effect once EnableTOTP confirms that the
+// user's authenticator produces valid codes.
+func (db *DB) SetTOTPSecret(userId int, sealedSecret string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, getErr := db.getUser(userId)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	if user.TOTPEnabled {
+		return ErrTOTPEnabled
+	}
+
+	user.TOTPSecret = sealedSecret
+	db.dbStructure.Users[userId] = user
+
+	return db.writeDB(db.dbStructure)
+}
+
+// EnableTOTP completes enrolment after the user entered the code of step.
+// It returns the recovery codes, which are only stored hashed.
+func (db *DB) EnableTOTP(userId int, step int64) ([]string, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, getErr := db.getUser(userId)
+
+	if getErr != nil {
+		return nil, getErr
+	}
+
+	if user.TOTPEnabled {
+		return nil, ErrTOTPEnabled
+	}
+
+	if len(user.TOTPSecret) == 0 {
+		return nil, ErrTOTPNotEnabled
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+
+	for range recoveryCodeCount {
+		code, codeErr := newRecoveryCode()
+
+		if codeErr != nil {
+			return nil, codeErr
+		}
+
+		codes = append(codes, code)
+		hashes = append(hashes, hashToken(normalizeRecoveryCode(code)))
+	}
+
+	user.TOTPEnabled = true
+	user.TOTPLastStep = step
+	user.RecoveryCodeHashes = hashes
+	db.dbStructure.Users[userId] = user
+
+	err := db.writeDB(db.dbStructure)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseTOTPStep records that a code of step was accepted for the user. Codes
+// of that step or an earlier one fail with ErrTOTPCodeUsed, so a code seen
+// by an attacker can't be replayed.
+func (db *DB) UseTOTPStep(userId int, step int64) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, getErr := db.getUser(userId)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+
+	if step <= user.TOTPLastStep {
+		return ErrTOTPCodeUsed
+	}
+
+	user.TOTPLastStep = step
+	db.dbStructure.Users[userId] = user
+
+	return db.writeDB(db.dbStructure)
+}
+
+// UseRecoveryCode consumes one of the user's recovery codes.
+func (db *DB) UseRecoveryCode(userId int, code string) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, getErr := db.getUser(userId)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	if !user.TOTPEnabled {
+		return ErrTOTPNotEnabled
+	}
+
+	hash := hashToken(normalizeRecoveryCode(code))
+
+	for i, candidate := range user.RecoveryCodeHashes {
+		if equalHashes(candidate, hash) {
+			user.RecoveryCodeHashes = append(user.RecoveryCodeHashes[:i:i], user.RecoveryCodeHashes[i+1:]...)
+			db.dbStructure.Users[userId] = user
+			return db.writeDB(db.dbStructure)
+		}
+	}
+
+	return ErrInvalidRecoveryCode
+}
+
+// DisableTOTP turns two-factor authentication off and forgets the secret
+// and recovery codes.
+func (db *DB) DisableTOTP(userId int) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, getErr := db.getUser(userId)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPEnabled = false
+	user.TOTPLastStep = 0
+	user.RecoveryCodeHashes = nil
+	db.dbStructure.Users[userId] = user
+
+	return db.writeDB(db.dbStructure)
+}
+
+// newRecoveryCode returns a code such as "k3mzq-7fwpa".
+func newRecoveryCode() (string, error) {
+	data := make([]byte, 10)
+	_, readErr := rand.Read(data)
+
+	if readErr != nil {
+		return "", readErr
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(data))[:10]
+
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes, which users tend
+// to get wrong when typing codes.
+func normalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
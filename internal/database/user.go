@@ -1,18 +1,69 @@
 package database
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	Password    string `json:"password"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id            int    `json:"id"`
+	Email         string `json:"email"`
+	Username      string `json:"username"`
+	DisplayName   string `json:"display_name"`
+	Bio           string `json:"bio"`
+	AvatarUrl     string `json:"avatar_url"`
+	PinnedChirpId int    `json:"pinned_chirp_id"`
+	Password      string `json:"password"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	IsAdmin       bool   `json:"is_admin"`
+	Suspended     bool   `json:"suspended"`
+	// TokensValidAfter rejects access tokens issued before it. It is set
+	// when the password changes.
+	TokensValidAfter time.Time `json:"tokens_valid_after"`
+	// TOTPSecret is the encrypted secret of the user's authenticator. It is
+	// set on enrolment and only checked once TOTPEnabled is set.
+	TOTPSecret  string `json:"totp_secret,omitempty"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// TOTPLastStep is the time step of the last accepted code, so each code
+	// works only once.
+	TOTPLastStep       int64    `json:"totp_last_step,omitempty"`
+	RecoveryCodeHashes []string `json:"recovery_code_hashes,omitempty"`
 }
 
+// ProfileUpdate holds the public profile fields to change. Nil fields are
+// left untouched; an empty string clears the field, except for Username,
+// which can be changed but not cleared.
+type ProfileUpdate struct {
+	Username    *string
+	DisplayName *string
+	Bio         *string
+	AvatarUrl   *string
+	// PinnedChirpId must name one of the user's own published chirps, or be 0
+	// to unpin.
+	PinnedChirpId *int
+}
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+)
+
+var (
+	ErrSuspended       = errors.New("account suspended")
+	ErrUsernameTaken   = errors.New("username already taken")
+	ErrInvalidUsername = errors.New("username must be 3-30 letters, digits or underscores")
+	ErrInvalidProfile  = errors.New("invalid profile")
+)
+
+var usernamePattern = regexp.MustCompile(`^\w{3,30}$`)
+
 func (db *DB) toHash(text string) (string, error) {
 	hashed, bcryptErr := bcrypt.GenerateFromPassword([]byte(text), bcrypt.DefaultCost)
 
@@ -24,13 +75,25 @@ func (db *DB) toHash(text string) (string, error) {
 }
 
 func (db *DB) DeleteUser(id int) error {
-	_, ok := db.dbStructure.Users[id]
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, ok := db.dbStructure.Users[id]
 
 	if !ok {
 		return fmt.Errorf("user not found")
 	}
 
 	delete(db.dbStructure.Users, id)
+	delete(db.usernames, strings.ToLower(user.Username))
+	db.deleteUserSessions(id)
+	db.deleteUserPersonalAccessTokens(id)
+
+	for codeId, grant := range db.dbStructure.OAuthCodes {
+		if grant.UserId == id {
+			delete(db.dbStructure.OAuthCodes, codeId)
+		}
+	}
 
 	err := db.writeDB(db.dbStructure)
 
@@ -42,6 +105,9 @@ func (db *DB) DeleteUser(id int) error {
 }
 
 func (db *DB) LoginUser(email, password string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
 	for _, user := range db.dbStructure.Users {
 		if user.Email == email {
 			compareErr := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password))
@@ -50,6 +116,10 @@ func (db *DB) LoginUser(email, password string) (User, error) {
 				return User{}, fmt.Errorf("password is incorrect")
 			}
 
+			if user.Suspended {
+				return User{}, ErrSuspended
+			}
+
 			return user, nil
 		}
 	}
@@ -59,6 +129,9 @@ func (db *DB) LoginUser(email, password string) (User, error) {
 
 func (db *DB) CreateUser(email, password string) (User, error) {
 
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
 	if len(email) == 0 || len(password) == 0 {
 		return User{}, fmt.Errorf("email and password cannot be empty")
 	}
@@ -93,7 +166,10 @@ func (db *DB) CreateUser(email, password string) (User, error) {
 
 func (db *DB) UpdateUser(id int, newEmail, newPassword string, isChirpyRed bool) (User, error) {
 
-	user, getErr := db.GetUser(id)
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, getErr := db.getUser(id)
 
 	if getErr != nil {
 		return User{}, getErr
@@ -109,6 +185,7 @@ func (db *DB) UpdateUser(id int, newEmail, newPassword string, isChirpyRed bool)
 			return User{}, bcryptErr
 		}
 		user.Password = string(hashed)
+		user = db.revokeUserTokens(user)
 	}
 
 	user.IsChirpyRed = isChirpyRed
@@ -124,6 +201,9 @@ func (db *DB) UpdateUser(id int, newEmail, newPassword string, isChirpyRed bool)
 }
 
 func (db *DB) GetUsers() ([]User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
 	users := make([]User, 0)
 
 	for _, user := range db.dbStructure.Users {
@@ -134,6 +214,13 @@ func (db *DB) GetUsers() ([]User, error) {
 }
 
 func (db *DB) GetUser(id int) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.getUser(id)
+}
+
+func (db *DB) getUser(id int) (User, error) {
 	user, ok := db.dbStructure.Users[id]
 
 	if !ok {
@@ -143,6 +230,136 @@ func (db *DB) GetUser(id int) (User, error) {
 	return user, nil
 }
 
+// SetAdmin grants or revokes moderator rights for the user with the given
+// email.
+func (db *DB) SetAdmin(email string, isAdmin bool) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	for id, user := range db.dbStructure.Users {
+		if user.Email == email {
+			user.IsAdmin = isAdmin
+			db.dbStructure.Users[id] = user
+			return db.writeDB(db.dbStructure)
+		}
+	}
+
+	return fmt.Errorf("user not found")
+}
+
+// GetUserByUsername looks a user up by handle, ignoring case.
+func (db *DB) GetUserByUsername(username string) (User, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.getUserByUsername(username)
+}
+
+func (db *DB) getUserByUsername(username string) (User, error) {
+	id, ok := db.usernames[strings.ToLower(username)]
+
+	if !ok {
+		return User{}, fmt.Errorf("there's no user of %s", username)
+	}
+
+	return db.getUser(id)
+}
+
+func (db *DB) UpdateProfile(id int, update ProfileUpdate) (User, error) {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	user, getErr := db.getUser(id)
+
+	if getErr != nil {
+		return User{}, getErr
+	}
+
+	oldKey := strings.ToLower(user.Username)
+
+	if update.Username != nil {
+		if !usernamePattern.MatchString(*update.Username) {
+			return User{}, ErrInvalidUsername
+		}
+
+		ownerId, taken := db.usernames[strings.ToLower(*update.Username)]
+		if taken && ownerId != id {
+			return User{}, ErrUsernameTaken
+		}
+
+		user.Username = *update.Username
+	}
+
+	if update.DisplayName != nil {
+		if utf8.RuneCountInString(*update.DisplayName) > maxDisplayNameLength {
+			return User{}, fmt.Errorf("%w: display name is too long", ErrInvalidProfile)
+		}
+		user.DisplayName = *update.DisplayName
+	}
+
+	if update.Bio != nil {
+		if utf8.RuneCountInString(*update.Bio) > maxBioLength {
+			return User{}, fmt.Errorf("%w: bio is too long", ErrInvalidProfile)
+		}
+		user.Bio = *update.Bio
+	}
+
+	if update.AvatarUrl != nil {
+		if len(*update.AvatarUrl) > 0 && !isHttpUrl(*update.AvatarUrl) {
+			return User{}, fmt.Errorf("%w: avatar url must be an http(s) url", ErrInvalidProfile)
+		}
+		user.AvatarUrl = *update.AvatarUrl
+	}
+
+	if update.PinnedChirpId != nil {
+		if *update.PinnedChirpId != 0 {
+			chirp, ok := db.dbStructure.Chirps[*update.PinnedChirpId]
+			if !ok || chirp.AuthorId != id || chirp.Pending || chirp.DeletedAt != nil {
+				return User{}, fmt.Errorf("%w: you can only pin your own published chirps", ErrInvalidProfile)
+			}
+		}
+		user.PinnedChirpId = *update.PinnedChirpId
+	}
+
+	db.dbStructure.Users[user.Id] = user
+
+	if len(oldKey) > 0 {
+		delete(db.usernames, oldKey)
+	}
+	if len(user.Username) > 0 {
+		db.usernames[strings.ToLower(user.Username)] = user.Id
+	}
+
+	dbErr := db.writeDB(db.dbStructure)
+	if dbErr != nil {
+		return User{}, dbErr
+	}
+
+	return user, nil
+}
+
+func isHttpUrl(text string) bool {
+	parsed, err := url.Parse(text)
+
+	if err != nil {
+		return false
+	}
+
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && len(parsed.Host) > 0
+}
+
+// buildUsernameIndex maps lower-cased usernames to user ids so handle lookups
+// are case-insensitive and unique.
+func (db *DB) buildUsernameIndex() {
+	db.usernames = map[string]int{}
+
+	for _, user := range db.dbStructure.Users {
+		if len(user.Username) > 0 {
+			db.usernames[strings.ToLower(user.Username)] = user.Id
+		}
+	}
+}
+
 func (db *DB) existUser(email string) bool {
 	for _, user := range db.dbStructure.Users {
 		if user.Email == email {
@@ -0,0 +1,235 @@
+// Package keyring holds the keys used to sign and verify access tokens.
+// Each key has an id that is written to the "kid" header of the tokens it
+// signs, so keys can be rotated without invalidating tokens signed by
+// older keys that are still in the ring.
+package keyring
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKey  = errors.New("unknown signing key")
+	ErrNoActiveKey = errors.New("no active signing key")
+)
+
+type key struct {
+	id      string
+	method  jwt.SigningMethod
+	signing any
+	verify  any
+}
+
+// Keyring is a set of keys, one of which is active and used for signing.
+// It is built at startup and not modified afterwards.
+type Keyring struct {
+	keys   map[string]key
+	active string
+}
+
+func New() *Keyring {
+	return &Keyring{keys: map[string]key{}}
+}
+
+// AddHMAC adds an HS256 secret. An empty id matches tokens without a kid
+// header, as issued before keys had ids.
+func (k *Keyring) AddHMAC(id string, secret []byte) {
+	k.keys[id] = key{id: id, method: jwt.SigningMethodHS256, signing: secret, verify: secret}
+}
+
+// AddPrivateKey adds an Ed25519 (EdDSA) or RSA (RS256) private key.
+func (k *Keyring) AddPrivateKey(id string, private crypto.Signer) error {
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		k.keys[id] = key{id: id, method: jwt.SigningMethodEdDSA, signing: private, verify: private.Public()}
+	case *rsa.PrivateKey:
+		k.keys[id] = key{id: id, method: jwt.SigningMethodRS256, signing: private, verify: &private.PublicKey}
+	default:
+		return fmt.Errorf("unsupported key type %T for %q", private, id)
+	}
+
+	return nil
+}
+
+// LoadDir adds every PKCS #8 or PKCS #1 PEM private key in dir. The key id
+// is the file name without its ".pem" extension.
+func (k *Keyring) LoadDir(dir string) error {
+	paths, globErr := filepath.Glob(filepath.Join(dir, "*.pem"))
+
+	if globErr != nil {
+		return globErr
+	}
+
+	for _, path := range paths {
+		data, readErr := os.ReadFile(path)
+
+		if readErr != nil {
+			return readErr
+		}
+
+		private, parseErr := parsePrivateKey(data)
+
+		if parseErr != nil {
+			return fmt.Errorf("%s: %w", path, parseErr)
+		}
+
+		addErr := k.AddPrivateKey(strings.TrimSuffix(filepath.Base(path), ".pem"), private)
+
+		if addErr != nil {
+			return addErr
+		}
+	}
+
+	return nil
+}
+
+// SetActive selects the key used to sign new tokens.
+func (k *Keyring) SetActive(id string) error {
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	k.active = id
+
+	return nil
+}
+
+// Sign signs claims with the active key.
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	active, ok := k.keys[k.active]
+
+	if !ok {
+		return "", ErrNoActiveKey
+	}
+
+	token := jwt.NewWithClaims(active.method, claims)
+
+	if len(active.id) > 0 {
+		token.Header["kid"] = active.id
+	}
+
+	return token.SignedString(active.signing)
+}
+
+// Keyfunc finds the verification key named by a token's kid header. The
+// token's algorithm must match the key, so an RSA public key can never be
+// used as an HMAC secret.
+func (k *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	id, _ := token.Header["kid"].(string)
+	found, ok := k.keys[id]
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	if token.Method.Alg() != found.method.Alg() {
+		return nil, fmt.Errorf("%w: %q is not an %s key", ErrUnknownKey, id, token.Method.Alg())
+	}
+
+	return found.verify, nil
+}
+
+// Methods lists the algorithms of the keys in the ring.
+func (k *Keyring) Methods() []string {
+	seen := map[string]bool{}
+	methods := make([]string, 0)
+
+	for _, found := range k.keys {
+		if alg := found.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+
+	sort.Strings(methods)
+
+	return methods
+}
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid"`
+	Use     string `json:"use"`
+	Alg     string `json:"alg"`
+	Curve   string `json:"crv,omitempty"`
+	X       string `json:"x,omitempty"`
+	N       string `json:"n,omitempty"`
+	E       string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the ring. HMAC secrets are never
+// published.
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0)}
+	encoding := base64.RawURLEncoding
+
+	for _, found := range k.keys {
+		jwk := JWK{KeyId: found.id, Use: "sig", Alg: found.method.Alg()}
+
+		switch public := found.verify.(type) {
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = encoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = encoding.EncodeToString(public.N.Bytes())
+			jwk.E = encoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool {
+		return set.Keys[i].KeyId < set.Keys[j].KeyId
+	})
+
+	return set
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	parsed, parseErr := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,82 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	for name, private := range map[string]any{"ed-1": edKey, "rsa-2": rsaKey} {
+		der, marshalErr := x509.MarshalPKCS8PrivateKey(private)
+		if marshalErr != nil {
+			t.Fatalf("Error encoding key: %v", marshalErr)
+		}
+
+		data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		if writeErr := os.WriteFile(filepath.Join(dir, name+".pem"), data, 0600); writeErr != nil {
+			t.Fatalf("Error writing key: %v", writeErr)
+		}
+	}
+
+	keys := New()
+	keys.AddHMAC("", []byte("legacy"))
+
+	if loadErr := keys.LoadDir(dir); loadErr != nil {
+		t.Fatalf("Error loading keys: %v", loadErr)
+	}
+
+	claims := jwt.RegisteredClaims{Subject: "1"}
+
+	legacy, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("legacy"))
+
+	keys.SetActive("ed-1")
+	old, signErr := keys.Sign(claims)
+	if signErr != nil {
+		t.Fatalf("Error signing: %v", signErr)
+	}
+
+	keys.SetActive("rsa-2")
+	current, _ := keys.Sign(claims)
+
+	for _, token := range []string{legacy, old, current} {
+		if _, parseErr := jwt.Parse(token, keys.Keyfunc); parseErr != nil {
+			t.Errorf("Expected %q to verify, got %v", token, parseErr)
+		}
+	}
+
+	if jwks := keys.JWKS(); len(jwks.Keys) != 2 || jwks.Keys[0].KeyType != "OKP" || jwks.Keys[1].KeyType != "RSA" {
+		t.Errorf("Expected the two public keys to be published, got %+v", jwks)
+	}
+
+	// A token claiming HS256 with the RSA key id must not be checked
+	// against the public key as a secret.
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = "rsa-2"
+	forgedString, _ := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+
+	if _, parseErr := jwt.Parse(forgedString, keys.Keyfunc); !errors.Is(parseErr, ErrUnknownKey) {
+		t.Errorf("Expected %v, got %v", ErrUnknownKey, parseErr)
+	}
+
+	os.Remove(filepath.Join(dir, "ed-1.pem"))
+	retired := New()
+	retired.LoadDir(dir)
+
+	if _, parseErr := jwt.Parse(old, retired.Keyfunc); !errors.Is(parseErr, ErrUnknownKey) {
+		t.Errorf("Expected tokens of a retired key to fail, got %v", parseErr)
+	}
+}
@@ -0,0 +1,235 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const (
+	thumbnailSize  = 320
+	maxImagePixels = 40_000_000
+)
+
+var ErrUnsupportedType = errors.New("unsupported media type")
+
+var extensions = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+}
+
+// Stored describes a file written to the store. Paths are relative to the
+// store root, using forward slashes so they can be served as URLs.
+type Stored struct {
+	Hash          string
+	ContentType   string
+	Size          int64
+	Width         int
+	Height        int
+	Path          string
+	ThumbnailPath string
+	// New is set when this Save wrote the file, rather than finding the same
+	// content already stored.
+	New bool
+}
+
+type Store struct {
+	root   string
+	prefix string
+}
+
+// NewStore returns a store writing under dir. prefix is the path of dir
+// relative to the directory served to clients, e.g. "assets/media".
+func NewStore(dir, prefix string) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{root: dir, prefix: prefix}, nil
+}
+
+// Sniff returns the detected content type of data if it is a supported image.
+func Sniff(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+
+	if _, ok := extensions[contentType]; !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	return contentType, nil
+}
+
+// Save stores data under a path derived from its SHA-256 hash, together with
+// a PNG thumbnail. Saving the same content twice reuses the existing files.
+func (s *Store) Save(data []byte) (Stored, error) {
+	contentType, sniffErr := Sniff(data)
+
+	if sniffErr != nil {
+		return Stored{}, sniffErr
+	}
+
+	config, _, configErr := image.DecodeConfig(bytes.NewReader(data))
+
+	if configErr != nil {
+		return Stored{}, fmt.Errorf("%w: %v", ErrUnsupportedType, configErr)
+	}
+
+	if config.Width*config.Height > maxImagePixels {
+		return Stored{}, fmt.Errorf("%w: image is too large", ErrUnsupportedType)
+	}
+
+	hash := ContentHash(data)
+
+	dir := filepath.Join(s.root, hash[:2])
+	fileName := hash + extensions[contentType]
+	thumbnailName := hash + "_thumb.png"
+
+	mkdirErr := os.MkdirAll(dir, 0755)
+
+	if mkdirErr != nil {
+		return Stored{}, mkdirErr
+	}
+
+	_, statErr := os.Stat(filepath.Join(dir, fileName))
+	isNew := os.IsNotExist(statErr)
+
+	writeErr := writeIfMissing(filepath.Join(dir, fileName), data)
+
+	if writeErr != nil {
+		return Stored{}, writeErr
+	}
+
+	thumbnailPath := filepath.Join(dir, thumbnailName)
+
+	if _, statErr := os.Stat(thumbnailPath); os.IsNotExist(statErr) {
+		img, _, decodeErr := image.Decode(bytes.NewReader(data))
+
+		if decodeErr != nil {
+			return Stored{}, fmt.Errorf("%w: %v", ErrUnsupportedType, decodeErr)
+		}
+
+		encoded := bytes.Buffer{}
+		encodeErr := png.Encode(&encoded, Thumbnail(img, thumbnailSize))
+
+		if encodeErr != nil {
+			return Stored{}, encodeErr
+		}
+
+		writeErr = writeIfMissing(thumbnailPath, encoded.Bytes())
+
+		if writeErr != nil {
+			return Stored{}, writeErr
+		}
+	}
+
+	return Stored{
+		Hash:          hash,
+		ContentType:   contentType,
+		Size:          int64(len(data)),
+		Width:         config.Width,
+		Height:        config.Height,
+		Path:          s.prefix + "/" + hash[:2] + "/" + fileName,
+		ThumbnailPath: s.prefix + "/" + hash[:2] + "/" + thumbnailName,
+		New:           isNew,
+	}, nil
+}
+
+// Delete removes a stored file and its thumbnail.
+func (s *Store) Delete(stored Stored) error {
+	dir := filepath.Join(s.root, stored.Hash[:2])
+
+	for _, name := range []string{stored.Hash + extensions[stored.ContentType], stored.Hash + "_thumb.png"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ContentHash returns the SHA-256 hash files are stored under.
+func ContentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Thumbnail scales img down so that neither side exceeds size, keeping the
+// aspect ratio. Each output pixel averages the source pixels it covers.
+func Thumbnail(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= size && height <= size {
+		size = max(width, height)
+	}
+
+	newWidth, newHeight := size, size
+	if width > height {
+		newHeight = max(1, height*size/width)
+	} else {
+		newWidth = max(1, width*size/height)
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+
+	for y := 0; y < newHeight; y++ {
+		y0 := bounds.Min.Y + y*height/newHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/newHeight)
+
+		for x := 0; x < newWidth; x++ {
+			x0 := bounds.Min.X + x*width/newWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/newWidth)
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					count++
+				}
+			}
+
+			offset := thumbnail.PixOffset(x, y)
+			thumbnail.Pix[offset+0] = uint8(r / count >> 8)
+			thumbnail.Pix[offset+1] = uint8(g / count >> 8)
+			thumbnail.Pix[offset+2] = uint8(b / count >> 8)
+			thumbnail.Pix[offset+3] = uint8(a / count >> 8)
+		}
+	}
+
+	return thumbnail
+}
+
+func writeIfMissing(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+
+	if os.IsExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, writeErr := file.Write(data)
+	closeErr := file.Close()
+
+	if writeErr != nil {
+		os.Remove(path)
+		return writeErr
+	}
+
+	return closeErr
+}
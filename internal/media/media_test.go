@@ -0,0 +1,81 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	store, newStoreErr := NewStore(dir, "assets/media")
+	if newStoreErr != nil {
+		t.Fatalf("Error creating store: %v", newStoreErr)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for x := 0; x < 800; x++ {
+		for y := 0; y < 400; y++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	encoded := bytes.Buffer{}
+	if err := png.Encode(&encoded, img); err != nil {
+		t.Fatalf("Error encoding image: %v", err)
+	}
+
+	stored, saveErr := store.Save(encoded.Bytes())
+	if saveErr != nil {
+		t.Fatalf("Error saving image: %v", saveErr)
+	}
+
+	if stored.ContentType != "image/png" || stored.Width != 800 || stored.Height != 400 {
+		t.Errorf("Unexpected stored image %v", stored)
+	}
+
+	if filepath.Base(stored.Path) != stored.Hash+".png" {
+		t.Errorf("Expected content-addressed path, got %v", stored.Path)
+	}
+
+	thumbnailFile, openErr := os.Open(filepath.Join(dir, stored.Hash[:2], stored.Hash+"_thumb.png"))
+	if openErr != nil {
+		t.Fatalf("Error opening thumbnail: %v", openErr)
+	}
+	defer thumbnailFile.Close()
+
+	thumbnail, decodeErr := png.Decode(thumbnailFile)
+	if decodeErr != nil {
+		t.Fatalf("Error decoding thumbnail: %v", decodeErr)
+	}
+
+	if thumbnail.Bounds().Dx() != thumbnailSize || thumbnail.Bounds().Dy() != thumbnailSize/2 {
+		t.Errorf("Expected %dx%d thumbnail, got %v", thumbnailSize, thumbnailSize/2, thumbnail.Bounds())
+	}
+
+	if !stored.New {
+		t.Errorf("Expected first save to write new files")
+	}
+
+	if again, _ := store.Save(encoded.Bytes()); again.New {
+		t.Errorf("Expected second save to reuse the stored files")
+	}
+
+	if deleteErr := store.Delete(stored); deleteErr != nil {
+		t.Errorf("Error deleting: %v", deleteErr)
+	}
+
+	if _, statErr := os.Stat(filepath.Join(dir, stored.Hash[:2], stored.Hash+".png")); !os.IsNotExist(statErr) {
+		t.Errorf("Expected file to be deleted, got %v", statErr)
+	}
+
+	_, textErr := store.Save([]byte("not an image"))
+	if !errors.Is(textErr, ErrUnsupportedType) {
+		t.Errorf("Expected %v, got %v", ErrUnsupportedType, textErr)
+	}
+}
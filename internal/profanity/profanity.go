@@ -0,0 +1,135 @@
+package profanity
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const Mask = "****"
+
+var DefaultWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// Filter masks banned words in text. It is safe for concurrent use, and its
+// word list can be replaced while it is in use.
+type Filter struct {
+	mux   *sync.RWMutex
+	words map[string]bool
+	path  string
+}
+
+func NewFilter(words []string) *Filter {
+	filter := &Filter{mux: &sync.RWMutex{}}
+	filter.SetWords(words)
+
+	return filter
+}
+
+// NewFilterFromFile returns a filter using the word list at path. Reload
+// re-reads the same file.
+func NewFilterFromFile(path string) (*Filter, error) {
+	filter := &Filter{mux: &sync.RWMutex{}, words: map[string]bool{}, path: path}
+
+	err := filter.Reload()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func (f *Filter) SetWords(words []string) {
+	set := map[string]bool{}
+
+	for _, word := range words {
+		word = strings.ToLower(strings.TrimSpace(word))
+		if len(word) > 0 {
+			set[word] = true
+		}
+	}
+
+	f.mux.Lock()
+	defer f.mux.Unlock()
+
+	f.words = set
+}
+
+// Reload re-reads the word file the filter was created from. The file holds
+// one word per line; blank lines and lines starting with # are ignored. On
+// error the current list stays in place.
+func (f *Filter) Reload() error {
+	if len(f.path) == 0 {
+		return nil
+	}
+
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	words := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+
+	if scanErr := scanner.Err(); scanErr != nil {
+		return scanErr
+	}
+
+	f.SetWords(words)
+
+	return nil
+}
+
+// Clean replaces every banned word in text with Mask, matching whole words
+// case-insensitively. Punctuation and spacing around words are kept. The
+// second result reports whether anything was replaced.
+func (f *Filter) Clean(text string) (string, bool) {
+	f.mux.RLock()
+	defer f.mux.RUnlock()
+
+	builder := strings.Builder{}
+	censored := false
+	wordStart := -1
+
+	flush := func(end int) {
+		word := text[wordStart:end]
+		if f.words[strings.ToLower(word)] {
+			builder.WriteString(Mask)
+			censored = true
+		} else {
+			builder.WriteString(word)
+		}
+		wordStart = -1
+	}
+
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if wordStart < 0 {
+				wordStart = i
+			}
+			continue
+		}
+
+		if wordStart >= 0 {
+			flush(i)
+		}
+		builder.WriteRune(r)
+	}
+
+	if wordStart >= 0 {
+		flush(len(text))
+	}
+
+	return builder.String(), censored
+}
@@ -0,0 +1,58 @@
+package profanity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	filter := NewFilter(DefaultWords)
+
+	testData := []struct {
+		text     string
+		expected string
+		censored bool
+	}{
+		{text: "I had something interesting for breakfast", expected: "I had something interesting for breakfast", censored: false},
+		{text: "I hear Mastodon is better than Chirpy. sharbert I need to migrate", expected: "I hear Mastodon is better than Chirpy. **** I need to migrate", censored: true},
+		{text: "I really need a Kerfuffle, to go to bed sooner, Fornax!", expected: "I really need a ****, to go to bed sooner, ****!", censored: true},
+		{text: "kerfuffles and (sharbert) and sharbert's", expected: "kerfuffles and (****) and ****'s", censored: true},
+	}
+
+	for _, data := range testData {
+		cleaned, censored := filter.Clean(data.text)
+		if cleaned != data.expected || censored != data.censored {
+			t.Errorf("Expected %q (%v), got %q (%v)", data.expected, data.censored, cleaned, censored)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+
+	if err := os.WriteFile(path, []byte("# banned\nfoo\n"), 0644); err != nil {
+		t.Fatalf("Error writing word list: %v", err)
+	}
+
+	filter, newErr := NewFilterFromFile(path)
+	if newErr != nil {
+		t.Fatalf("Error creating filter: %v", newErr)
+	}
+
+	if cleaned, _ := filter.Clean("foo bar"); cleaned != "**** bar" {
+		t.Errorf("Expected %q, got %q", "**** bar", cleaned)
+	}
+
+	if err := os.WriteFile(path, []byte("bar\n"), 0644); err != nil {
+		t.Fatalf("Error writing word list: %v", err)
+	}
+
+	if err := filter.Reload(); err != nil {
+		t.Fatalf("Error reloading filter: %v", err)
+	}
+
+	if cleaned, _ := filter.Clean("foo bar"); cleaned != "foo ****" {
+		t.Errorf("Expected %q, got %q", "foo ****", cleaned)
+	}
+}
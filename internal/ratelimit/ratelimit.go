@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Rate allows Requests per Per, with bursts of up to Requests.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// Result describes the state of a bucket after a call to Allow.
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter is a set of token buckets, one per key. Buckets refill
+// continuously, so a client that spends its burst regains one request every
+// Per/Requests.
+type Limiter struct {
+	mux     sync.Mutex
+	buckets map[string]*bucket
+	idle    time.Duration
+	swept   time.Time
+}
+
+// NewLimiter returns a limiter that forgets buckets unused for idle.
+func NewLimiter(idle time.Duration) *Limiter {
+	return &Limiter{
+		buckets: map[string]*bucket{},
+		idle:    idle,
+	}
+}
+
+// Allow takes one token from the bucket for key, refilled at rate. The rate
+// may differ between calls for the same key, e.g. after an account upgrade.
+func (l *Limiter) Allow(key string, rate Rate, now time.Time) Result {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	if rate.Requests <= 0 || rate.Per <= 0 {
+		return Result{Allowed: true}
+	}
+
+	l.sweep(now)
+
+	capacity := float64(rate.Requests)
+	interval := rate.Per / time.Duration(rate.Requests)
+
+	b, ok := l.buckets[key]
+
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		l.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+float64(elapsed)/float64(interval))
+		b.last = now
+	}
+
+	result := Result{Limit: rate.Requests}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) * float64(interval))
+	}
+
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((capacity - b.tokens) * float64(interval))
+
+	return result
+}
+
+// sweep drops idle buckets at most once per idle period. A full bucket holds
+// no state worth keeping.
+func (l *Limiter) sweep(now time.Time) {
+	if l.idle <= 0 || now.Sub(l.swept) < l.idle {
+		return
+	}
+
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.idle {
+			delete(l.buckets, key)
+		}
+	}
+
+	l.swept = now
+}
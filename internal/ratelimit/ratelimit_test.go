@@ -0,0 +1,40 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := NewLimiter(time.Hour)
+	rate := Rate{Requests: 3, Per: time.Minute}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		if result := limiter.Allow("a", rate, now); !result.Allowed || result.Remaining != 2-i {
+			t.Errorf("Expected request %d to be allowed with %d remaining, got %+v", i, 2-i, result)
+		}
+	}
+
+	result := limiter.Allow("a", rate, now)
+	if result.Allowed || result.RetryAfter != 20*time.Second {
+		t.Errorf("Expected to be throttled for 20s, got %+v", result)
+	}
+
+	if other := limiter.Allow("b", rate, now); !other.Allowed {
+		t.Errorf("Expected a separate bucket per key")
+	}
+
+	if result := limiter.Allow("a", rate, now.Add(20*time.Second)); !result.Allowed {
+		t.Errorf("Expected a token to be refilled, got %+v", result)
+	}
+
+	higher := Rate{Requests: 6, Per: time.Minute}
+	if result := limiter.Allow("a", higher, now.Add(30*time.Second)); !result.Allowed || result.Limit != 6 {
+		t.Errorf("Expected the higher rate to apply, got %+v", result)
+	}
+
+	if result := limiter.Allow("a", Rate{}, now); !result.Allowed {
+		t.Errorf("Expected a zero rate to disable limiting")
+	}
+}
@@ -0,0 +1,77 @@
+// Package secretbox encrypts small secrets, such as TOTP seeds, before they
+// are written to the database.
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+// KeySize is the key length, selecting AES-256.
+const KeySize = 32
+
+var (
+	ErrInvalidKey = errors.New("secretbox key must be 32 bytes")
+	ErrDecrypt    = errors.New("secretbox: message authentication failed")
+)
+
+// Box seals and opens secrets with AES-GCM under one key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+func New(key []byte) (*Box, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, blockErr := aes.NewCipher(key)
+
+	if blockErr != nil {
+		return nil, blockErr
+	}
+
+	aead, gcmErr := cipher.NewGCM(block)
+
+	if gcmErr != nil {
+		return nil, gcmErr
+	}
+
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext under a random nonce. additionalData, such as
+// the id of the record the secret belongs to, isn't stored but must be
+// given again to Open, so a sealed secret can't be moved to another record.
+func (box *Box) Seal(plaintext, additionalData []byte) (string, error) {
+	nonce := make([]byte, box.aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := box.aead.Seal(nonce, nonce, plaintext, additionalData)
+
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open decrypts a secret sealed by Seal with the same additionalData.
+func (box *Box) Open(sealed string, additionalData []byte) ([]byte, error) {
+	data, decodeErr := base64.RawStdEncoding.DecodeString(sealed)
+
+	if decodeErr != nil || len(data) < box.aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, ciphertext := data[:box.aead.NonceSize()], data[box.aead.NonceSize():]
+	plaintext, openErr := box.aead.Open(nil, nonce, ciphertext, additionalData)
+
+	if openErr != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
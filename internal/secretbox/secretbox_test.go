@@ -0,0 +1,32 @@
+package secretbox
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	if _, keyErr := New([]byte("short")); !errors.Is(keyErr, ErrInvalidKey) {
+		t.Errorf("Expected %v, got %v", ErrInvalidKey, keyErr)
+	}
+
+	box, _ := New(bytes.Repeat([]byte{1}, KeySize))
+	sealed, sealErr := box.Seal([]byte("secret"), []byte("user:1"))
+	if sealErr != nil {
+		t.Fatalf("Error sealing: %v", sealErr)
+	}
+
+	if opened, openErr := box.Open(sealed, []byte("user:1")); openErr != nil || string(opened) != "secret" {
+		t.Errorf("Expected secret back, got %q, %v", opened, openErr)
+	}
+
+	if _, openErr := box.Open(sealed, []byte("user:2")); !errors.Is(openErr, ErrDecrypt) {
+		t.Errorf("Expected %v for other additional data, got %v", ErrDecrypt, openErr)
+	}
+
+	other, _ := New(bytes.Repeat([]byte{2}, KeySize))
+	if _, openErr := other.Open(sealed, []byte("user:1")); !errors.Is(openErr, ErrDecrypt) {
+		t.Errorf("Expected %v for another key, got %v", ErrDecrypt, openErr)
+	}
+}
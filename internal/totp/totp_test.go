@@ -0,0 +1,49 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+
+	// The six-digit truncations of the SHA-1 test vectors of RFC 6238.
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range cases {
+		if code := Code(secret, Step(time.Unix(unix, 0))); code != expected {
+			t.Errorf("Expected %s at %d, got %s", expected, unix, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, _ := GenerateSecret()
+	now := time.Unix(1_700_000_000, 0)
+	previous := Code(secret, Step(now)-1)
+
+	if step, ok := Validate(secret, previous, now, 1); !ok || step != Step(now)-1 {
+		t.Errorf("Expected code of the previous step to be accepted, got %d, %v", step, ok)
+	}
+
+	if _, ok := Validate(secret, previous, now, 0); ok {
+		t.Errorf("Expected code of the previous step to be refused without skew")
+	}
+
+	if _, ok := Validate(secret, "12345", now, 1); ok {
+		t.Errorf("Expected short code to be refused")
+	}
+
+	if uri := KeyURI("Chirpy", "a@b.com", secret); !strings.HasPrefix(uri, "otpauth://totp/Chirpy:a@b.com?") || !strings.Contains(uri, "secret="+EncodeSecret(secret)) {
+		t.Errorf("Unexpected key URI %s", uri)
+	}
+}
@@ -1,24 +1,57 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/joho/godotenv"
+	"github.com/walrus811/chirpy/internal/chirptext"
 	"github.com/walrus811/chirpy/internal/database"
+	"github.com/walrus811/chirpy/internal/keyring"
+	"github.com/walrus811/chirpy/internal/media"
+	"github.com/walrus811/chirpy/internal/profanity"
+	"github.com/walrus811/chirpy/internal/ratelimit"
+	"github.com/walrus811/chirpy/internal/secretbox"
 )
 
 type apiConfig struct {
-	fileserverHits int
-	jwtSecret      string
-	polkaKey       string
+	fileserverHits  int
+	tokens          *jwtConfig
+	polkaKey        string
+	db              *database.DB
+	mediaStore      *media.Store
+	maxUploadBytes  int64
+	mediaQuotaBytes int64
+	profanityFilter *profanity.Filter
+	maxChirpLength  int
+	maxRedLength    int
+	restoreGrace    time.Duration
+	trashRetention  time.Duration
+	refreshTTL      time.Duration
+	// rateLimits maps a route group to its per-client allowance.
+	rateLimits        map[string]ratelimit.Rate
+	rateLimiter       *ratelimit.Limiter
+	redRateMultiplier int
+	// idempotencySecret keys the HMAC that fingerprints request bodies for
+	// idempotency checks.
+	idempotencySecret []byte
+	// totpSecrets encrypts the authenticator secrets of users. Two-factor
+	// authentication is unavailable when it is nil.
+	totpSecrets *secretbox.Box
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
@@ -37,6 +70,9 @@ func main() {
 	const filepathRoot = "."
 	const port = "8080"
 	const dbPath = "database.json"
+	const mediaDir = "assets/media"
+	const schedulerInterval = 10 * time.Second
+	const purgeInterval = time.Hour
 
 	db, dbErr := database.NewDB(dbPath)
 	if dbErr != nil {
@@ -44,7 +80,120 @@ func main() {
 		return
 	}
 
-	cfg := &apiConfig{fileserverHits: 0, jwtSecret: os.Getenv("JWT_SECRET"), polkaKey: os.Getenv("POLKA_KEY")}
+	// ADMIN_EMAILS is a comma-separated list of accounts granted moderator
+	// rights at startup.
+	for _, email := range strings.Split(os.Getenv("ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); len(email) > 0 {
+			if adminErr := db.SetAdmin(email, true); adminErr != nil {
+				fmt.Println("Error granting admin to", email)
+			}
+		}
+	}
+
+	// Issue iat with millisecond precision, so a token issued right after a
+	// password change isn't mistaken for one issued before it.
+	jwt.TimePrecision = time.Millisecond
+
+	// JWT_SECRET signs tokens without a key id. JWT_KEYS_DIR holds Ed25519 or
+	// RSA private keys named <kid>.pem, and JWT_ACTIVE_KID picks the one that
+	// signs new tokens. Keys stay valid for verification until removed.
+	keys := keyring.New()
+	if secret := os.Getenv("JWT_SECRET"); len(secret) > 0 {
+		keys.AddHMAC("", []byte(secret))
+	}
+	if keysDir := os.Getenv("JWT_KEYS_DIR"); len(keysDir) > 0 {
+		if keysErr := keys.LoadDir(keysDir); keysErr != nil {
+			fmt.Println("Error loading signing keys:", keysErr)
+			return
+		}
+	}
+	if activeErr := keys.SetActive(os.Getenv("JWT_ACTIVE_KID")); activeErr != nil {
+		fmt.Println("Error selecting signing key:", activeErr)
+		return
+	}
+
+	// Access tokens must name this server as issuer and JWT_AUDIENCE as
+	// audience. JWT_LEEWAY_SECONDS allows for clock skew between servers.
+	tokens := &jwtConfig{
+		keys:        keys,
+		issuer:      jwtIssuer,
+		audience:    getEnvString("JWT_AUDIENCE", "chirpy-api"),
+		leeway:      time.Duration(getEnvInt64("JWT_LEEWAY_SECONDS", 30)) * time.Second,
+		revocations: db,
+	}
+
+	// TOTP_ENCRYPTION_KEY is a hex-encoded 32-byte key that encrypts the
+	// authenticator secrets of users. Without it, two-factor enrolment is off.
+	var totpSecrets *secretbox.Box
+	if totpKey := os.Getenv("TOTP_ENCRYPTION_KEY"); len(totpKey) > 0 {
+		key, _ := hex.DecodeString(totpKey)
+		box, boxErr := secretbox.New(key)
+		if boxErr != nil {
+			fmt.Println("Error loading TOTP encryption key:", boxErr)
+			return
+		}
+		totpSecrets = box
+	}
+
+	// IDEMPOTENCY_SECRET keys the fingerprints of idempotent requests. Without
+	// it a random key is used, and retries across a restart are refused as
+	// mismatches.
+	idempotencySecret := []byte(os.Getenv("IDEMPOTENCY_SECRET"))
+	if len(idempotencySecret) == 0 {
+		idempotencySecret = make([]byte, 32)
+		if _, randErr := rand.Read(idempotencySecret); randErr != nil {
+			fmt.Println("Error generating idempotency secret")
+			return
+		}
+	}
+
+	mediaStore, mediaErr := media.NewStore(filepath.Join(filepathRoot, mediaDir), mediaDir)
+	if mediaErr != nil {
+		fmt.Println("Error creating media store")
+		return
+	}
+
+	profanityFilter := profanity.NewFilter(profanity.DefaultWords)
+	if profanityPath := os.Getenv("PROFANITY_FILE"); len(profanityPath) > 0 {
+		filter, filterErr := profanity.NewFilterFromFile(profanityPath)
+		if filterErr != nil {
+			fmt.Println("Error loading profanity word list")
+			return
+		}
+		profanityFilter = filter
+	}
+
+	// Send SIGHUP to pick up changes to the profanity word list.
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if reloadErr := profanityFilter.Reload(); reloadErr != nil {
+				fmt.Println("Error reloading profanity word list:", reloadErr)
+			}
+		}
+	}()
+
+	cfg := &apiConfig{
+		fileserverHits:    0,
+		tokens:            tokens,
+		polkaKey:          os.Getenv("POLKA_KEY"),
+		db:                db,
+		mediaStore:        mediaStore,
+		maxUploadBytes:    getEnvInt64("MEDIA_MAX_BYTES", 5<<20),
+		mediaQuotaBytes:   getEnvInt64("MEDIA_QUOTA_BYTES", 100<<20),
+		profanityFilter:   profanityFilter,
+		maxChirpLength:    int(getEnvInt64("CHIRP_MAX_LENGTH", 140)),
+		maxRedLength:      int(getEnvInt64("CHIRP_MAX_LENGTH_RED", 280)),
+		restoreGrace:      time.Duration(getEnvInt64("CHIRP_RESTORE_HOURS", 7*24)) * time.Hour,
+		trashRetention:    time.Duration(getEnvInt64("CHIRP_RETENTION_HOURS", 30*24)) * time.Hour,
+		refreshTTL:        time.Duration(getEnvInt64("REFRESH_TOKEN_TTL_HOURS", 60*24)) * time.Hour,
+		rateLimits:        loadRateLimits(),
+		rateLimiter:       ratelimit.NewLimiter(time.Hour),
+		redRateMultiplier: int(getEnvInt64("RATE_LIMIT_RED_MULTIPLIER", 1)),
+		idempotencySecret: idempotencySecret,
+		totpSecrets:       totpSecrets,
+	}
 	mux := http.NewServeMux()
 
 	mux.Handle("GET /app/*", http.StripPrefix("/app/", cfg.middlewareMetricsInc(http.FileServer(http.Dir(filepathRoot)))))
@@ -74,7 +223,7 @@ func main() {
 		w.Header().Add("Content-Type", "text/plain;charset=UTF-8")
 		w.Write([]byte("Hits reset to 0"))
 	})
-	mux.HandleFunc("GET /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("GET /api/chirps/{chirpID}", cfg.middlewareOptionalAuth(scopeChirpsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
 
 		if err != nil {
@@ -82,15 +231,18 @@ func main() {
 			return
 		}
 
-		chirp, getErr := db.GetChirp(chirpID)
+		viewer, _ := principalFromRequest(r)
+		viewerId := viewer.UserId
+
+		chirp, getErr := db.GetChirp(chirpID, viewerId)
 		if getErr != nil {
 			respondWithError(w, http.StatusNotFound, "not found")
 			return
 		}
 
 		respondWithJson(w, http.StatusOK, chirp)
-	})
-	mux.HandleFunc("GET /api/chirps", func(w http.ResponseWriter, r *http.Request) {
+	})))
+	mux.Handle("GET /api/chirps", cfg.middlewareOptionalAuth(scopeChirpsRead, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		asc := true
 
 		sortString := r.URL.Query().Get("sort")
@@ -101,6 +253,9 @@ func main() {
 
 		authorIdString := r.URL.Query().Get("author_id")
 
+		viewer, _ := principalFromRequest(r)
+		viewerId := viewer.UserId
+
 		if len(authorIdString) > 0 {
 			authorId, atoiErr := strconv.Atoi(authorIdString)
 
@@ -109,9 +264,18 @@ func main() {
 				return
 			}
 
-			chrips, err := db.GetChirpsByAuthorId(authorId)
+			chrips, err := db.GetChirpsByAuthorId(authorId, viewerId)
 
+			pinnedChirpId := 0
+			if author, getAuthorErr := db.GetUser(authorId); getAuthorErr == nil {
+				pinnedChirpId = author.PinnedChirpId
+			}
+
+			// The author's pinned chirp always comes first.
 			sort.Slice(chrips, func(i, j int) bool {
+				if chrips[i].Id == pinnedChirpId || chrips[j].Id == pinnedChirpId {
+					return chrips[i].Id == pinnedChirpId
+				}
 				if asc {
 					return chrips[i].Id < chrips[j].Id
 				}
@@ -124,7 +288,7 @@ func main() {
 			}
 			respondWithJson(w, http.StatusOK, chrips)
 		} else {
-			chrips, err := db.GetChirps()
+			chrips, err := db.GetChirps(viewerId)
 
 			sort.Slice(chrips, func(i, j int) bool {
 				if asc {
@@ -139,31 +303,11 @@ func main() {
 			}
 			respondWithJson(w, http.StatusOK, chrips)
 		}
-	})
-
-	mux.HandleFunc("POST /api/chirps", func(w http.ResponseWriter, r *http.Request) {
-		authKey := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-
-		jwtClaim, getJwtClainErr := getJWTClaim(cfg.jwtSecret, authKey)
-
-		if getJwtClainErr != nil {
-			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
-			return
-		}
-
-		userIdStr, getSubjectErr := jwtClaim.GetSubject()
-
-		if getSubjectErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
-			return
-		}
+	})))
 
-		userId, atoiErr := strconv.Atoi(userIdStr)
-
-		if atoiErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
-			return
-		}
+	mux.Handle("POST /api/chirps", cfg.middlewareRateLimit(rateLimitWrite, cfg.middlewareAuth(scopeChirpsWrite, cfg.middlewareIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromRequest(r)
+		userId := caller.UserId
 
 		decoder := json.NewDecoder(r.Body)
 		reqObj := createChirpRequest{}
@@ -173,45 +317,53 @@ func main() {
 			return
 		}
 
-		if len(reqObj.Body) > 140 {
-			respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		if !cfg.checkChirpLength(w, userId, reqObj.Body) {
 			return
 		}
 
-		newChirp, createErr := db.CreateChirp(reqObj.Body, userId)
+		cleanedBody, censored := cfg.profanityFilter.Clean(reqObj.Body)
 
-		if createErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
-			return
+		options := database.ChirpOptions{
+			MediaIds:   reqObj.MediaIds,
+			Censored:   censored,
+			PublishAt:  reqObj.PublishAt,
+			Visibility: reqObj.Visibility,
 		}
 
-		respondWithJson(w, http.StatusCreated, newChirp)
-	})
+		if reqObj.Poll != nil {
+			options.PollOptions = reqObj.Poll.Options
+			options.PollClosesAt = reqObj.Poll.ClosesAt
 
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", func(w http.ResponseWriter, r *http.Request) {
-		authKey := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
+			if len(options.PollOptions) == 0 {
+				respondWithError(w, http.StatusBadRequest, "A poll needs options")
+				return
+			}
+		}
 
-		jwtClaim, getJwtClainErr := getJWTClaim(cfg.jwtSecret, authKey)
+		newChirp, createErr := db.CreateChirp(cleanedBody, userId, options)
 
-		if getJwtClainErr != nil {
-			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		if errors.Is(createErr, database.ErrInvalidChirp) {
+			respondWithError(w, http.StatusBadRequest, createErr.Error())
 			return
 		}
 
-		userIdStr, getSubjectErr := jwtClaim.GetSubject()
-
-		if getSubjectErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		if errors.Is(createErr, database.ErrSuspended) {
+			respondWithError(w, http.StatusForbidden, "Account suspended")
 			return
 		}
 
-		userId, atoiErr := strconv.Atoi(userIdStr)
-
-		if atoiErr != nil {
+		if createErr != nil {
 			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
 			return
 		}
 
+		respondWithJson(w, http.StatusCreated, newChirp)
+	})))))
+
+	mux.Handle("DELETE /api/chirps/{chirpID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromRequest(r)
+		userId := caller.UserId
+
 		chirpID, getChirpIDErr := strconv.Atoi(r.PathValue("chirpID"))
 
 		if getChirpIDErr != nil {
@@ -219,10 +371,10 @@ func main() {
 			return
 		}
 
-		chirp, getChirpErr := db.GetChirp(chirpID)
+		chirp, getChirpErr := db.GetChirp(chirpID, userId)
 
 		if getChirpErr != nil {
-			respondWithError(w, http.StatusForbidden, "Forbidden")
+			respondWithError(w, http.StatusNotFound, "not found")
 			return
 		}
 
@@ -239,9 +391,9 @@ func main() {
 		}
 
 		respondWithJson(w, http.StatusNoContent, nil)
-	})
+	})))
 
-	mux.HandleFunc("POST /api/users", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("POST /api/users", cfg.middlewareRateLimit(rateLimitAuth, cfg.middlewareIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		reqObj := createUserRequest{}
 		err := decoder.Decode(&reqObj)
@@ -260,9 +412,9 @@ func main() {
 		resObj := createUserResponse{newUser.Id, newUser.Email, newUser.IsChirpyRed}
 
 		respondWithJson(w, http.StatusCreated, resObj)
-	})
+	}))))
 
-	mux.HandleFunc("POST /api/login", func(w http.ResponseWriter, r *http.Request) {
+	mux.Handle("POST /api/login", cfg.middlewareRateLimit(rateLimitAuth, cfg.middlewareIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		decoder := json.NewDecoder(r.Body)
 		reqObj := loginUserRequest{}
 		err := decoder.Decode(&reqObj)
@@ -273,107 +425,122 @@ func main() {
 
 		user, loginErr := db.LoginUser(reqObj.Email, reqObj.Password)
 
+		if errors.Is(loginErr, database.ErrSuspended) {
+			respondWithError(w, http.StatusForbidden, "Account suspended")
+			return
+		}
+
 		if loginErr != nil {
 			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
-		token, tokenErr := getJWTString(cfg.jwtSecret, strconv.Itoa(user.Id))
+		// Users with two-factor authentication get a challenge to trade for
+		// tokens at /api/login/2fa along with a code.
+		if user.TOTPEnabled {
+			challenge, challengeErr := getLoginChallenge(cfg.tokens, user.Id, reqObj.Device)
 
-		if tokenErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			if challengeErr != nil {
+				respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+				return
+			}
+
+			skipIdempotentResponse(r)
+			respondWithJson(w, http.StatusOK, loginChallengeResponse{true, challenge, int(loginChallengeTTL.Seconds())})
 			return
 		}
 
-		refreshToken, CreateRefreshTokenErr := db.CreateRefreshToken(user.Id)
+		cfg.respondWithLogin(w, r, user, reqObj.Device)
+	}))))
 
-		if CreateRefreshTokenErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+	mux.Handle("POST /api/login/2fa", cfg.middlewareRateLimit(rateLimitAuth, http.HandlerFunc(cfg.handlerLoginTwoFactor)))
+
+	mux.Handle("PUT /api/users", cfg.middlewareAuth(scopeProfileWrite, http.HandlerFunc(cfg.handlerUsersUpdate)))
+
+	mux.HandleFunc("GET /api/users/{username}", func(w http.ResponseWriter, r *http.Request) {
+		user, getErr := db.GetUserByUsername(r.PathValue("username"))
+
+		if getErr != nil {
+			respondWithError(w, http.StatusNotFound, "not found")
 			return
 		}
 
-		resObj := loginUserResponse{user.Id, user.Email, user.IsChirpyRed, token, refreshToken}
+		resObj := publicProfileResponse{user.Id, user.Username, user.DisplayName, user.Bio, user.AvatarUrl, user.PinnedChirpId, user.IsChirpyRed}
 
 		respondWithJson(w, http.StatusOK, resObj)
 	})
 
-	mux.HandleFunc("PUT /api/users", func(w http.ResponseWriter, r *http.Request) {
-		authKey := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-
-		jwtClaim, getJwtClainErr := getJWTClaim(cfg.jwtSecret, authKey)
+	mux.Handle("POST /api/refresh", cfg.middlewareRateLimit(rateLimitAuth, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		refershToken, tokenErr := bearerToken(r)
 
-		if getJwtClainErr != nil {
-			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		if tokenErr != nil {
+			respondUnauthorized(w, tokenErr)
 			return
 		}
 
-		userIdStr, getSubjectErr := jwtClaim.GetSubject()
+		session, rotateErr := db.RotateSession(refershToken, clientIP(r), cfg.refreshTTL)
 
-		if getSubjectErr != nil {
-			fmt.Println(getSubjectErr)
-			respondWithError(w, http.StatusInternalServerError, "Unauthorized")
-			return
+		if errors.Is(rotateErr, database.ErrTokenReused) {
+			logTokenReuse(session)
 		}
 
-		userId, atoiErr := strconv.Atoi(userIdStr)
-
-		if atoiErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		if errors.Is(rotateErr, database.ErrSuspended) {
+			respondWithError(w, http.StatusForbidden, "Account suspended")
 			return
 		}
 
-		decoder := json.NewDecoder(r.Body)
-		reqObj := updateUserRequest{}
-		err := decoder.Decode(&reqObj)
-		if err != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		if rotateErr != nil {
+			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
 			return
 		}
 
-		user, updateErr := db.UpdateUser(userId, reqObj.Email, reqObj.Password, false)
+		newToken, getTokenErr := getJWTString(cfg.tokens, strconv.Itoa(session.UserId))
 
-		if updateErr != nil {
+		if getTokenErr != nil {
 			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
 			return
 		}
 
-		resObj := updateUserResponse{user.Id, user.Email, user.IsChirpyRed}
+		resObj := refershTokrnResponse{newToken, session.Token}
 
 		respondWithJson(w, http.StatusOK, resObj)
-	})
-
-	mux.HandleFunc("POST /api/refresh", func(w http.ResponseWriter, r *http.Request) {
-		refershToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
+	})))
 
-		userId, getUserIdErr := db.GetUserIdByToken(refershToken)
+	mux.HandleFunc("POST /api/revoke", func(w http.ResponseWriter, r *http.Request) {
+		refershToken, tokenErr := bearerToken(r)
 
-		if getUserIdErr != nil {
-			respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		if tokenErr != nil {
+			respondUnauthorized(w, tokenErr)
 			return
 		}
 
-		newToken, getTokenErr := getJWTString(cfg.jwtSecret, strconv.Itoa(userId))
+		// Clients logging out can pass their access token too, so it stops
+		// working right away instead of at its expiry. The body is optional.
+		reqObj := revokeRequest{}
+		decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
 
-		if getTokenErr != nil {
-			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		if decodeErr != nil && !errors.Is(decodeErr, io.EOF) {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
 			return
 		}
 
-		resObj := refershTokrnResponse{newToken}
-
-		respondWithJson(w, http.StatusOK, resObj)
-	})
-
-	mux.HandleFunc("POST /api/revoke", func(w http.ResponseWriter, r *http.Request) {
-		refershToken := strings.Split(r.Header.Get("Authorization"), "Bearer ")[1]
-
-		deleteErr := db.DeleteRefreshToken(refershToken)
+		session, deleteErr := db.DeleteSessionByToken(refershToken)
 
 		if deleteErr != nil {
 			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
 			return
 		}
 
+		// Only the access tokens of the session's own user can be revoked
+		// here, so a refresh token can't be used to log out someone else.
+		if len(reqObj.Token) > 0 && session.UserId != 0 {
+			jwtClaim, claimErr := getJWTClaim(cfg.tokens, reqObj.Token)
+
+			if claimErr == nil && jwtClaim.Subject == strconv.Itoa(session.UserId) {
+				db.RevokeAccessToken(jwtClaim.ID, jwtClaim.ExpiresAt.Time)
+			}
+		}
+
 		w.WriteHeader(http.StatusNoContent)
 	})
 
@@ -421,11 +588,64 @@ func main() {
 		w.WriteHeader(http.StatusNoContent)
 	})
 
-	http.ListenAndServe(":"+port, mux)
+	mux.Handle("POST /api/users/{username}/follow", cfg.middlewareAuth(scopeProfileWrite, http.HandlerFunc(cfg.handlerFollow)))
+	mux.Handle("DELETE /api/users/{username}/follow", cfg.middlewareAuth(scopeProfileWrite, http.HandlerFunc(cfg.handlerUnfollow)))
+	mux.Handle("POST /api/chirps/{chirpID}/vote", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerChirpsVote)))
+	mux.Handle("POST /api/bookmarks/{chirpID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerBookmarksCreate)))
+	mux.Handle("DELETE /api/bookmarks/{chirpID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerBookmarksDelete)))
+	mux.Handle("GET /api/bookmarks", cfg.middlewareAuth(scopeChirpsRead, http.HandlerFunc(cfg.handlerBookmarksList)))
+	mux.Handle("POST /api/users/{username}/block", cfg.middlewareAuth(scopeProfileWrite, cfg.handlerRelationCreate(database.RelationBlock)))
+	mux.Handle("DELETE /api/users/{username}/block", cfg.middlewareAuth(scopeProfileWrite, cfg.handlerRelationDelete(database.RelationBlock)))
+	mux.Handle("GET /api/blocks", cfg.middlewareAuth(scopeProfileWrite, cfg.handlerRelationList(database.RelationBlock)))
+	mux.Handle("POST /api/users/{username}/mute", cfg.middlewareAuth(scopeProfileWrite, cfg.handlerRelationCreate(database.RelationMute)))
+	mux.Handle("DELETE /api/users/{username}/mute", cfg.middlewareAuth(scopeProfileWrite, cfg.handlerRelationDelete(database.RelationMute)))
+	mux.Handle("GET /api/mutes", cfg.middlewareAuth(scopeProfileWrite, cfg.handlerRelationList(database.RelationMute)))
+	mux.Handle("POST /api/chirps/{chirpID}/report", cfg.middlewareRateLimit(rateLimitWrite, cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerChirpsReport))))
+	mux.Handle("GET /admin/reports", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerReportsList)))
+	mux.Handle("POST /admin/reports/{reportID}/hide", cfg.middlewareAuth(scopeAll, cfg.handlerReportsModerate(database.ModerationHideChirp)))
+	mux.Handle("POST /admin/reports/{reportID}/remove", cfg.middlewareAuth(scopeAll, cfg.handlerReportsModerate(database.ModerationRemoveChirp)))
+	mux.Handle("POST /admin/reports/{reportID}/suspend", cfg.middlewareAuth(scopeAll, cfg.handlerReportsModerate(database.ModerationSuspendUser)))
+	mux.Handle("POST /admin/reports/{reportID}/close", cfg.middlewareAuth(scopeAll, cfg.handlerReportsModerate(database.ModerationCloseReport)))
+	mux.Handle("GET /admin/moderation", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerModerationLog)))
+	mux.Handle("GET /api/chirps/trash", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerChirpsTrash)))
+	mux.Handle("POST /api/chirps/{chirpID}/restore", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerChirpsRestore)))
+	mux.Handle("GET /api/chirps/scheduled", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerScheduledChirpsGet)))
+	mux.Handle("DELETE /api/chirps/scheduled/{chirpID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerScheduledChirpsDelete)))
+	mux.Handle("POST /api/drafts", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerDraftsCreate)))
+	mux.Handle("GET /api/drafts", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerDraftsList)))
+	mux.Handle("GET /api/drafts/{draftID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerDraftsGet)))
+	mux.Handle("PUT /api/drafts/{draftID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerDraftsUpdate)))
+	mux.Handle("DELETE /api/drafts/{draftID}", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerDraftsDelete)))
+	mux.Handle("POST /api/drafts/{draftID}/publish", cfg.middlewareRateLimit(rateLimitWrite, cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerDraftsPublish))))
+	mux.Handle("POST /api/media", cfg.middlewareRateLimit(rateLimitWrite, cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(cfg.handlerMediaUpload))))
+	mux.HandleFunc("GET /.well-known/jwks.json", cfg.handlerJWKS)
+	mux.Handle("GET /api/sessions", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerSessionsList)))
+	mux.Handle("DELETE /api/sessions/{sessionID}", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerSessionsDelete)))
+	mux.Handle("POST /api/tokens", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerTokensCreate)))
+	mux.Handle("GET /api/tokens", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerTokensList)))
+	mux.Handle("DELETE /api/tokens/{tokenID}", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerTokensDelete)))
+	cfg.registerOAuthRoutes(mux)
+	mux.Handle("POST /api/2fa", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerTwoFactorEnroll)))
+	mux.Handle("POST /api/2fa/confirm", cfg.middlewareRateLimit(rateLimitAuth, cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerTwoFactorConfirm))))
+	mux.Handle("DELETE /api/2fa", cfg.middlewareRateLimit(rateLimitAuth, cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerTwoFactorDisable))))
+	mux.Handle("GET /api/notifications", cfg.middlewareAuth(scopeNotificationsRead, http.HandlerFunc(cfg.handlerNotificationsGet)))
+	mux.Handle("POST /api/notifications/read", cfg.middlewareAuth(scopeNotificationsRead, http.HandlerFunc(cfg.handlerNotificationsRead)))
+
+	go cfg.runScheduler(schedulerInterval)
+	go cfg.runPurger(purgeInterval)
+
+	http.ListenAndServe(":"+port, cfg.middlewareRateLimit(rateLimitDefault, mux))
 }
 
 type createChirpRequest struct {
-	Body string `json:"body"`
+	Body       string     `json:"body"`
+	MediaIds   []int      `json:"media_ids"`
+	PublishAt  *time.Time `json:"publish_at"`
+	Visibility string     `json:"visibility"`
+	Poll       *struct {
+		Options  []string  `json:"options"`
+		ClosesAt time.Time `json:"closes_at"`
+	} `json:"poll"`
 }
 
 type createUserRequest struct {
@@ -436,11 +656,17 @@ type createUserRequest struct {
 type loginUserRequest struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Device   string `json:"device"`
 }
 
 type updateUserRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email         string  `json:"email"`
+	Password      string  `json:"password"`
+	Username      *string `json:"username"`
+	DisplayName   *string `json:"display_name"`
+	Bio           *string `json:"bio"`
+	AvatarUrl     *string `json:"avatar_url"`
+	PinnedChirpId *int    `json:"pinned_chirp_id"`
 }
 
 type polkaWebhookRequest struct {
@@ -457,9 +683,24 @@ type createUserResponse struct {
 }
 
 type updateUserResponse struct {
-	Id          int    `json:"id"`
-	Email       string `json:"email"`
-	IsChirpyRed bool   `json:"is_chirpy_red"`
+	Id            int    `json:"id"`
+	Email         string `json:"email"`
+	Username      string `json:"username"`
+	DisplayName   string `json:"display_name"`
+	Bio           string `json:"bio"`
+	AvatarUrl     string `json:"avatar_url"`
+	PinnedChirpId int    `json:"pinned_chirp_id"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+}
+
+type publicProfileResponse struct {
+	Id            int    `json:"id"`
+	Username      string `json:"username"`
+	DisplayName   string `json:"display_name"`
+	Bio           string `json:"bio"`
+	AvatarUrl     string `json:"avatar_url"`
+	PinnedChirpId int    `json:"pinned_chirp_id"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
 }
 
 type loginUserResponse struct {
@@ -470,10 +711,15 @@ type loginUserResponse struct {
 	RefreshToken string `json:"refresh_token"`
 }
 
-type refershTokrnResponse struct {
+type revokeRequest struct {
 	Token string `json:"token"`
 }
 
+type refershTokrnResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -490,23 +736,279 @@ func respondWithJson(w http.ResponseWriter, code int, payload interface{}) {
 	json.NewEncoder(w).Encode(payload)
 }
 
-func getJWTString(signKey, id string) (string, error) {
-	claim := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		Subject:   id,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(1) * time.Hour)),
+const (
+	jwtIssuer      = "chirpy"
+	accessTokenTTL = time.Hour
+)
+
+// jwtConfig holds the signing keys and the claims every access token must
+// carry.
+type jwtConfig struct {
+	keys        *keyring.Keyring
+	issuer      string
+	audience    string
+	leeway      time.Duration
+	revocations *database.DB
+}
+
+// accessTokenClaims are the claims of an access token. Tokens issued to
+// OAuth clients carry the granted scopes and the client id. Login tokens
+// carry neither and have full access.
+type accessTokenClaims struct {
+	jwt.RegisteredClaims
+	Scope    string `json:"scope,omitempty"`
+	ClientId string `json:"client_id,omitempty"`
+}
+
+func getJWTString(tokens *jwtConfig, id string) (string, error) {
+	return getScopedJWTString(tokens, id, "", nil)
+}
+
+// getScopedJWTString issues an access token limited to scopes for an OAuth
+// client.
+func getScopedJWTString(tokens *jwtConfig, id, clientId string, scopes []string) (string, error) {
+	jti, jtiErr := newTokenId()
+	if jtiErr != nil {
+		return "", jtiErr
+	}
+
+	claim := accessTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    tokens.issuer,
+			Audience:  jwt.ClaimStrings{tokens.audience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			Subject:   id,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(accessTokenTTL)),
+		},
+		Scope:    strings.Join(scopes, " "),
+		ClientId: clientId,
+	}
+	return tokens.keys.Sign(claim)
+}
+
+// newTokenId returns a random jti, so single tokens can be revoked.
+func newTokenId() (string, error) {
+	jti := make([]byte, 16)
+	if _, randErr := rand.Read(jti); randErr != nil {
+		return "", randErr
+	}
+
+	return hex.EncodeToString(jti), nil
+}
+
+// getJWTClaim parses and validates an access token. The algorithm must
+// match the key named by its kid, and iss, aud and exp are all required.
+// Revoked tokens fail with errRevokedToken.
+func getJWTClaim(tokens *jwtConfig, token string) (*accessTokenClaims, error) {
+	jwtClaim := &accessTokenClaims{}
+	_, err := jwt.ParseWithClaims(token, jwtClaim, tokens.keys.Keyfunc,
+		jwt.WithValidMethods(tokens.keys.Methods()),
+		jwt.WithIssuer(tokens.issuer),
+		jwt.WithAudience(tokens.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokens.leeway),
+	)
+
+	if err != nil {
+		return jwtClaim, err
+	}
+
+	userId, atoiErr := strconv.Atoi(jwtClaim.Subject)
+
+	if atoiErr != nil {
+		return jwtClaim, errInvalidToken
+	}
+
+	issuedAt := time.Time{}
+	if jwtClaim.IssuedAt != nil {
+		issuedAt = jwtClaim.IssuedAt.Time
 	}
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(signKey))
 
-	return token, err
+	if tokens.revocations.IsAccessTokenRevoked(jwtClaim.ID, userId, issuedAt) {
+		return jwtClaim, errRevokedToken
+	}
+
+	return jwtClaim, nil
+}
+
+// logRejectedToken records why an access token was refused.
+func logRejectedToken(err error) {
+	fmt.Println("Rejected access token:", jwtFailureReason(err))
 }
 
-func getJWTClaim(signKey, token string) (jwt.Claims, error) {
-	var jwtClaim jwt.Claims = &jwt.RegisteredClaims{}
-	_, err := jwt.ParseWithClaims(token, jwtClaim, func(token *jwt.Token) (interface{}, error) {
-		return []byte(signKey), nil
+func jwtFailureReason(err error) string {
+	switch {
+	case errors.Is(err, errRevokedToken):
+		return "revoked"
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return "malformed"
+	case errors.Is(err, keyring.ErrUnknownKey):
+		return "unknown key or algorithm"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return "bad signature"
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return "unverifiable"
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing):
+		return "missing required claim"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return "expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return "not valid yet"
+	case errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return "issued in the future"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return "wrong issuer"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return "wrong audience"
+	default:
+		return err.Error()
+	}
+}
+
+// getPagination reads the offset and limit query parameters, applying a
+// default page size and capping it.
+func getPagination(r *http.Request) (int, int, error) {
+	const defaultLimit = 20
+	const maxLimit = 100
+
+	offset := 0
+	limit := defaultLimit
+
+	if offsetString := r.URL.Query().Get("offset"); len(offsetString) > 0 {
+		parsed, err := strconv.Atoi(offsetString)
+		if err != nil || parsed < 0 {
+			return 0, 0, errors.New("invalid offset")
+		}
+		offset = parsed
+	}
+
+	if limitString := r.URL.Query().Get("limit"); len(limitString) > 0 {
+		parsed, err := strconv.Atoi(limitString)
+		if err != nil || parsed <= 0 {
+			return 0, 0, errors.New("invalid limit")
+		}
+		limit = min(parsed, maxLimit)
+	}
+
+	return offset, limit, nil
+}
+
+// getEnvInt64 reads an integer setting from the environment, falling back to
+// defaultValue when it is unset or malformed.
+func getEnvInt64(name string, defaultValue int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+
+	if err != nil {
+		return defaultValue
+	}
+
+	return value
+}
+
+// getEnvString reads a setting from the environment, falling back to
+// defaultValue when it is unset or empty.
+func getEnvString(name, defaultValue string) string {
+	if value := os.Getenv(name); len(value) > 0 {
+		return value
+	}
+
+	return defaultValue
+}
+
+var errChirpTooLong = errors.New("chirp is too long")
+
+// validateChirpLength checks body against the length limit of the author's
+// tier. Every path that creates or edits a chirp body must go through it.
+func (cfg *apiConfig) validateChirpLength(userId int, body string) error {
+	user, getErr := cfg.db.GetUser(userId)
+
+	if getErr != nil {
+		return getErr
+	}
+
+	limit := cfg.maxChirpLength
+	if user.IsChirpyRed {
+		limit = cfg.maxRedLength
+	}
+
+	if chirptext.Length(body) > limit {
+		return errChirpTooLong
+	}
+
+	return nil
+}
+
+// checkChirpLength runs validateChirpLength and writes the error response
+// when it fails. It reports whether the handler may continue.
+func (cfg *apiConfig) checkChirpLength(w http.ResponseWriter, userId int, body string) bool {
+	lengthErr := cfg.validateChirpLength(userId, body)
+
+	if errors.Is(lengthErr, errChirpTooLong) {
+		respondWithError(w, http.StatusBadRequest, "Chirp is too long")
+		return false
+	}
+
+	if lengthErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return false
+	}
+
+	return true
+}
+
+// handlerUsersUpdate edits the caller's profile. Changing the email or
+// password needs full access, so tokens scoped to profile:write can't take
+// over the account.
+func (cfg *apiConfig) handlerUsersUpdate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	decoder := json.NewDecoder(r.Body)
+	reqObj := updateUserRequest{}
+	err := decoder.Decode(&reqObj)
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if (len(reqObj.Email) > 0 || len(reqObj.Password) > 0) && !caller.HasScope(scopeAll) {
+		respondInsufficientScope(w, scopeAll)
+		return
+	}
+
+	profile, profileErr := cfg.db.UpdateProfile(userId, database.ProfileUpdate{
+		Username:      reqObj.Username,
+		DisplayName:   reqObj.DisplayName,
+		Bio:           reqObj.Bio,
+		AvatarUrl:     reqObj.AvatarUrl,
+		PinnedChirpId: reqObj.PinnedChirpId,
 	})
 
-	return jwtClaim, err
+	if errors.Is(profileErr, database.ErrUsernameTaken) {
+		respondWithError(w, http.StatusConflict, profileErr.Error())
+		return
+	}
+
+	if errors.Is(profileErr, database.ErrInvalidUsername) || errors.Is(profileErr, database.ErrInvalidProfile) {
+		respondWithError(w, http.StatusBadRequest, profileErr.Error())
+		return
+	}
+
+	if profileErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	user, updateErr := cfg.db.UpdateUser(userId, reqObj.Email, reqObj.Password, profile.IsChirpyRed)
+
+	if updateErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resObj := updateUserResponse{user.Id, user.Email, user.Username, user.DisplayName, user.Bio, user.AvatarUrl, user.PinnedChirpId, user.IsChirpyRed}
+
+	respondWithJson(w, http.StatusOK, resObj)
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/walrus811/chirpy/internal/database"
+	"github.com/walrus811/chirpy/internal/media"
+)
+
+func (cfg *apiConfig) handlerMediaUpload(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	// Leave room for the multipart framing around the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, cfg.maxUploadBytes+1<<20)
+
+	file, header, formErr := r.FormFile("file")
+
+	if formErr != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(formErr, &maxBytesErr) {
+			respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+			return
+		}
+		respondWithError(w, http.StatusBadRequest, "Missing file")
+		return
+	}
+	defer file.Close()
+
+	if header.Size > cfg.maxUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	data, readErr := io.ReadAll(io.LimitReader(file, cfg.maxUploadBytes+1))
+
+	if readErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid file")
+		return
+	}
+
+	if int64(len(data)) > cfg.maxUploadBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, "File is too large")
+		return
+	}
+
+	if _, sniffErr := media.Sniff(data); sniffErr != nil {
+		respondWithError(w, http.StatusUnsupportedMediaType, sniffErr.Error())
+		return
+	}
+
+	// Re-uploading a file returns the existing record without using more
+	// quota. Otherwise the quota is checked before anything is written.
+	if existing, ok := cfg.db.GetMediaByHash(userId, media.ContentHash(data)); ok {
+		respondWithJson(w, http.StatusCreated, existing)
+		return
+	}
+
+	if cfg.mediaQuotaBytes > 0 && cfg.db.GetMediaUsage(userId)+int64(len(data)) > cfg.mediaQuotaBytes {
+		respondWithError(w, http.StatusRequestEntityTooLarge, database.ErrQuotaExceeded.Error())
+		return
+	}
+
+	stored, saveErr := cfg.mediaStore.Save(data)
+
+	if errors.Is(saveErr, media.ErrUnsupportedType) {
+		respondWithError(w, http.StatusUnsupportedMediaType, saveErr.Error())
+		return
+	}
+
+	if saveErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	newMedia, createErr := cfg.db.CreateMedia(userId, database.Media{
+		Hash:          stored.Hash,
+		ContentType:   stored.ContentType,
+		Size:          stored.Size,
+		Width:         stored.Width,
+		Height:        stored.Height,
+		Path:          stored.Path,
+		ThumbnailPath: stored.ThumbnailPath,
+	}, cfg.mediaQuotaBytes)
+
+	// Don't leave files behind for an upload that wasn't recorded, unless
+	// they were already stored for someone else.
+	if createErr != nil && stored.New {
+		if deleteErr := cfg.mediaStore.Delete(stored); deleteErr != nil {
+			fmt.Println("Error deleting unrecorded upload:", deleteErr)
+		}
+	}
+
+	if errors.Is(createErr, database.ErrQuotaExceeded) {
+		respondWithError(w, http.StatusRequestEntityTooLarge, createErr.Error())
+		return
+	}
+
+	if createErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, newMedia)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type reportChirpRequest struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type moderateReportRequest struct {
+	Resolution string `json:"resolution"`
+	Note       string `json:"note"`
+}
+
+// getAdminIdFromRequest checks that the caller stored by middlewareAuth is
+// an admin. On failure it writes the error response and returns false.
+func (cfg *apiConfig) getAdminIdFromRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	user, getErr := cfg.db.GetUser(userId)
+
+	if getErr != nil || !user.IsAdmin {
+		respondWithError(w, http.StatusForbidden, "Forbidden")
+		return 0, false
+	}
+
+	return userId, true
+}
+
+func (cfg *apiConfig) handlerChirpsReport(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirpID, atoiErr := strconv.Atoi(r.PathValue("chirpID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	reqObj := reportChirpRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	report, reportErr := cfg.db.CreateReport(chirpID, userId, reqObj.Reason, reqObj.Details)
+
+	if errors.Is(reportErr, database.ErrInvalidReport) {
+		respondWithError(w, http.StatusBadRequest, reportErr.Error())
+		return
+	}
+
+	if reportErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, report)
+}
+
+func (cfg *apiConfig) handlerReportsList(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.getAdminIdFromRequest(w, r); !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	if len(status) == 0 {
+		status = database.ReportOpen
+	} else if status == "all" {
+		status = ""
+	}
+
+	reports, err := cfg.db.GetReports(status)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, reports)
+}
+
+// handlerReportsModerate returns a handler applying action to the report
+// named in the path.
+func (cfg *apiConfig) handlerReportsModerate(action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminId, ok := cfg.getAdminIdFromRequest(w, r)
+
+		if !ok {
+			return
+		}
+
+		reportID, atoiErr := strconv.Atoi(r.PathValue("reportID"))
+
+		if atoiErr != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid report ID")
+			return
+		}
+
+		reqObj := moderateReportRequest{}
+
+		if r.ContentLength != 0 {
+			decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+			if decodeErr != nil {
+				respondWithError(w, http.StatusBadRequest, "Invalid request body")
+				return
+			}
+		}
+
+		note := reqObj.Note
+		if action == database.ModerationCloseReport {
+			note = reqObj.Resolution
+		}
+
+		report, moderateErr := cfg.db.ModerateReport(reportID, adminId, action, note)
+
+		switch {
+		case moderateErr == nil:
+			respondWithJson(w, http.StatusOK, report)
+		case errors.Is(moderateErr, database.ErrReportClosed):
+			respondWithError(w, http.StatusConflict, moderateErr.Error())
+		case errors.Is(moderateErr, database.ErrInvalidReport):
+			respondWithError(w, http.StatusBadRequest, moderateErr.Error())
+		default:
+			respondWithError(w, http.StatusNotFound, "not found")
+		}
+	}
+}
+
+func (cfg *apiConfig) handlerModerationLog(w http.ResponseWriter, r *http.Request) {
+	if _, ok := cfg.getAdminIdFromRequest(w, r); !ok {
+		return
+	}
+
+	actions, err := cfg.db.GetModerationActions()
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, actions)
+}
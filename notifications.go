@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type getNotificationsResponse struct {
+	Notifications []database.Notification `json:"notifications"`
+	Total         int                     `json:"total"`
+	Unread        int                     `json:"unread"`
+	Offset        int                     `json:"offset"`
+	Limit         int                     `json:"limit"`
+}
+
+type readNotificationsRequest struct {
+	Ids []int `json:"ids"`
+}
+
+func (cfg *apiConfig) handlerNotificationsGet(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	offset, limit, paginationErr := getPagination(r)
+
+	if paginationErr != nil {
+		respondWithError(w, http.StatusBadRequest, paginationErr.Error())
+		return
+	}
+
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	notifications, total, err := cfg.db.GetNotifications(userId, unreadOnly, offset, limit)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resObj := getNotificationsResponse{notifications, total, cfg.db.CountUnreadNotifications(userId), offset, limit}
+
+	respondWithJson(w, http.StatusOK, resObj)
+}
+
+func (cfg *apiConfig) handlerNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	reqObj := readNotificationsRequest{}
+
+	if r.ContentLength != 0 {
+		decoder := json.NewDecoder(r.Body)
+		err := decoder.Decode(&reqObj)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	markErr := cfg.db.MarkNotificationsRead(userId, reqObj.Ids)
+
+	if markErr != nil {
+		respondWithError(w, http.StatusNotFound, "Notification not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type createOAuthClientRequest struct {
+	Name         string   `json:"name"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Confidential bool     `json:"confidential"`
+}
+
+type oauthClientResponse struct {
+	Id           int       `json:"id"`
+	ClientId     string    `json:"client_id"`
+	ClientSecret string    `json:"client_secret,omitempty"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// oauthErrorResponse is the error body of the token endpoint (RFC 6749
+// section 5.2).
+type oauthErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// authorizeRequest holds the parameters of an authorization request. They
+// travel from the query string of GET /oauth/authorize to the hidden fields
+// of the consent form.
+type authorizeRequest struct {
+	Client              database.OAuthClient
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+type consentPage struct {
+	authorizeRequest
+	Error string
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Authorize {{.Client.Name}}</title></head>
+<body>
+	<h1>{{.Client.Name}} wants to access your Chirpy account</h1>
+	<p>It will be able to:</p>
+	<ul>
+		{{range .Scopes}}<li>{{.}}</li>
+		{{end}}
+	</ul>
+	<p>It won't be able to change your email or password.</p>
+	{{if .Error}}<p role="alert">{{.Error}}</p>{{end}}
+	<form method="post" action="/oauth/authorize">
+		<input type="hidden" name="response_type" value="code">
+		<input type="hidden" name="client_id" value="{{.Client.ClientId}}">
+		<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+		<input type="hidden" name="scope" value="{{range $i, $scope := .Scopes}}{{if $i}} {{end}}{{$scope}}{{end}}">
+		<input type="hidden" name="state" value="{{.State}}">
+		<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+		<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+		<label>Email <input type="email" name="email" required></label>
+		<label>Password <input type="password" name="password" required></label>
+		<label>Authentication code, if two-factor authentication is on <input type="text" name="code" autocomplete="one-time-code"></label>
+		<button type="submit" name="decision" value="approve">Allow</button>
+		<button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+	</form>
+</body>
+</html>
+`))
+
+var oauthErrorTemplate = template.Must(template.New("oauth_error").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Authorization failed</title></head>
+<body>
+	<h1>Authorization failed</h1>
+	<p>{{.}}</p>
+</body>
+</html>
+`))
+
+// registerOAuthRoutes adds the OAuth 2.0 authorization server: client
+// registration, the consent page, and the token and revocation endpoints.
+// Only the authorization code grant with PKCE is supported.
+func (cfg *apiConfig) registerOAuthRoutes(mux *http.ServeMux) {
+	mux.Handle("POST /api/oauth/clients", cfg.middlewareAuth(scopeAll, http.HandlerFunc(cfg.handlerOAuthClientsCreate)))
+	mux.HandleFunc("GET /oauth/authorize", cfg.handlerOAuthAuthorizeGet)
+	mux.Handle("POST /oauth/authorize", cfg.middlewareRateLimit(rateLimitAuth, http.HandlerFunc(cfg.handlerOAuthAuthorizePost)))
+	mux.Handle("POST /oauth/token", cfg.middlewareRateLimit(rateLimitAuth, http.HandlerFunc(cfg.handlerOAuthToken)))
+	mux.HandleFunc("POST /oauth/revoke", cfg.handlerOAuthRevoke)
+}
+
+// handlerOAuthClientsCreate registers a third-party app owned by the
+// caller. The secret of a confidential client is only returned here.
+func (cfg *apiConfig) handlerOAuthClientsCreate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	reqObj := createOAuthClientRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	client, createErr := cfg.db.CreateOAuthClient(caller.UserId, reqObj.Name, reqObj.RedirectURIs, reqObj.Confidential)
+
+	if errors.Is(createErr, database.ErrInvalidOAuthClient) {
+		respondWithError(w, http.StatusBadRequest, createErr.Error())
+		return
+	}
+
+	if createErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, oauthClientResponse{client.Id, client.ClientId, client.Secret, client.Name, client.RedirectURIs, client.CreatedAt})
+}
+
+// parseAuthorizeRequest validates the parameters of an authorization
+// request. A bad client or redirect URI can't be reported to the client, so
+// it comes back as pageError to show the user. Any other problem comes back
+// as an RFC 6749 error code to send to the redirect URI.
+func (cfg *apiConfig) parseAuthorizeRequest(values url.Values) (req authorizeRequest, pageError string, errorCode string) {
+	client, clientErr := cfg.db.GetOAuthClient(values.Get("client_id"))
+
+	if clientErr != nil {
+		return req, "Unknown client.", ""
+	}
+
+	req.Client = client
+	req.RedirectURI = values.Get("redirect_uri")
+
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return req, "The redirect URI is not registered for this client.", ""
+	}
+
+	req.State = values.Get("state")
+	req.CodeChallenge = values.Get("code_challenge")
+	req.CodeChallengeMethod = values.Get("code_challenge_method")
+
+	if values.Get("response_type") != "code" {
+		return req, "", "unsupported_response_type"
+	}
+
+	// Only S256 is accepted. The plain method would hand the verifier to
+	// anyone who sees the authorization request.
+	if req.CodeChallengeMethod != "S256" || len(req.CodeChallenge) != 43 {
+		return req, "", "invalid_request"
+	}
+
+	scopes := strings.Fields(values.Get("scope"))
+
+	if len(scopes) == 0 {
+		return req, "", "invalid_scope"
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(knownScopes, scope) {
+			return req, "", "invalid_scope"
+		}
+	}
+
+	slices.Sort(scopes)
+	req.Scopes = slices.Compact(scopes)
+
+	return req, "", ""
+}
+
+// redirectToClient sends the user back to the client with params and the
+// state of the request.
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	target, _ := url.Parse(req.RedirectURI)
+	query := target.Query()
+
+	for name, values := range params {
+		query[name] = values
+	}
+
+	if len(req.State) > 0 {
+		query.Set("state", req.State)
+	}
+
+	target.RawQuery = query.Encode()
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func renderOAuthPage(w http.ResponseWriter, code int, tmpl *template.Template, data any) {
+	// The consent page must not be framed, or a malicious site could trick
+	// users into clicking Allow.
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	tmpl.Execute(w, data)
+}
+
+// handlerOAuthAuthorizeGet shows the consent page of an authorization
+// request.
+func (cfg *apiConfig) handlerOAuthAuthorizeGet(w http.ResponseWriter, r *http.Request) {
+	req, pageError, errorCode := cfg.parseAuthorizeRequest(r.URL.Query())
+
+	if len(pageError) > 0 {
+		renderOAuthPage(w, http.StatusBadRequest, oauthErrorTemplate, pageError)
+		return
+	}
+
+	if len(errorCode) > 0 {
+		redirectToClient(w, r, req, url.Values{"error": {errorCode}})
+		return
+	}
+
+	renderOAuthPage(w, http.StatusOK, consentTemplate, consentPage{authorizeRequest: req})
+}
+
+// handlerOAuthAuthorizePost handles the consent form. The user signs in
+// with their password, which the client never sees, and is sent back to
+// the client with an authorization code.
+func (cfg *apiConfig) handlerOAuthAuthorizePost(w http.ResponseWriter, r *http.Request) {
+	parseErr := r.ParseForm()
+
+	if parseErr != nil {
+		renderOAuthPage(w, http.StatusBadRequest, oauthErrorTemplate, "Invalid form")
+		return
+	}
+
+	req, pageError, errorCode := cfg.parseAuthorizeRequest(r.PostForm)
+
+	if len(pageError) > 0 {
+		renderOAuthPage(w, http.StatusBadRequest, oauthErrorTemplate, pageError)
+		return
+	}
+
+	if len(errorCode) > 0 {
+		redirectToClient(w, r, req, url.Values{"error": {errorCode}})
+		return
+	}
+
+	if r.PostForm.Get("decision") != "approve" {
+		redirectToClient(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	user, loginErr := cfg.db.LoginUser(r.PostForm.Get("email"), r.PostForm.Get("password"))
+
+	if errors.Is(loginErr, database.ErrSuspended) {
+		renderOAuthPage(w, http.StatusForbidden, consentTemplate, consentPage{req, "Your account is suspended."})
+		return
+	}
+
+	if loginErr != nil {
+		renderOAuthPage(w, http.StatusUnauthorized, consentTemplate, consentPage{req, "Incorrect email or password."})
+		return
+	}
+
+	if user.TOTPEnabled {
+		factorErr := cfg.verifySecondFactor(user, r.PostForm.Get("code"))
+
+		if errors.Is(factorErr, errInvalidSecondFactor) {
+			renderOAuthPage(w, http.StatusUnauthorized, consentTemplate, consentPage{req, "Enter a valid code from your authenticator app."})
+			return
+		}
+
+		if factorErr != nil {
+			redirectToClient(w, r, req, url.Values{"error": {"server_error"}})
+			return
+		}
+	}
+
+	code, codeErr := cfg.db.CreateOAuthCode(req.Client.ClientId, user.Id, req.RedirectURI, req.Scopes, req.CodeChallenge)
+
+	if codeErr != nil {
+		redirectToClient(w, r, req, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// respondWithOAuthError sends an error of the token or revocation
+// endpoint. Failed client authentication gets a Basic challenge.
+func respondWithOAuthError(w http.ResponseWriter, code int, errorCode, description string) {
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJson(w, code, oauthErrorResponse{errorCode, description})
+}
+
+// authenticateOAuthClient identifies the client of a token or revocation
+// request. Confidential clients send their secret with HTTP Basic or in the
+// form. Public clients only send client_id.
+func (cfg *apiConfig) authenticateOAuthClient(r *http.Request) (database.OAuthClient, error) {
+	clientId, secret, ok := r.BasicAuth()
+
+	if ok {
+		// Basic credentials are form-encoded first (RFC 6749 section 2.3.1).
+		clientId, _ = url.QueryUnescape(clientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientId = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	return cfg.db.AuthenticateOAuthClient(clientId, secret)
+}
+
+// handlerOAuthToken exchanges an authorization code or a refresh token for
+// an access token limited to the granted scopes.
+func (cfg *apiConfig) handlerOAuthToken(w http.ResponseWriter, r *http.Request) {
+	parseErr := r.ParseForm()
+
+	if parseErr != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+
+	client, clientErr := cfg.authenticateOAuthClient(r)
+
+	if clientErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	var session database.Session
+	var grantErr error
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		codeVerifier := r.PostForm.Get("code_verifier")
+
+		if len(codeVerifier) < 43 || len(codeVerifier) > 128 {
+			respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "code_verifier must be 43 to 128 characters")
+			return
+		}
+
+		session, grantErr = cfg.db.ExchangeOAuthCode(r.PostForm.Get("code"), client.ClientId, r.PostForm.Get("redirect_uri"), codeVerifier, sessionInfoFromRequest(r, ""), cfg.refreshTTL)
+	case "refresh_token":
+		session, grantErr = cfg.db.RotateClientSession(r.PostForm.Get("refresh_token"), client.ClientId, clientIP(r), cfg.refreshTTL)
+	default:
+		respondWithOAuthError(w, http.StatusBadRequest, "unsupported_grant_type", "")
+		return
+	}
+
+	if errors.Is(grantErr, database.ErrTokenReused) {
+		logTokenReuse(session)
+	}
+
+	if errors.Is(grantErr, database.ErrInvalidGrant) || errors.Is(grantErr, database.ErrSessionNotFound) || errors.Is(grantErr, database.ErrTokenReused) || errors.Is(grantErr, database.ErrSuspended) {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_grant", "")
+		return
+	}
+
+	if grantErr != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	accessToken, tokenErr := getScopedJWTString(cfg.tokens, strconv.Itoa(session.UserId), client.ClientId, session.Scopes)
+
+	if tokenErr != nil {
+		respondWithOAuthError(w, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	respondWithJson(w, http.StatusOK, oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTokenTTL.Seconds()),
+		RefreshToken: session.Token,
+		Scope:        strings.Join(session.Scopes, " "),
+	})
+}
+
+// handlerOAuthRevoke revokes a refresh token or an access token of the
+// calling client (RFC 7009). Unknown tokens and tokens of other clients
+// are ignored, so the response doesn't reveal whether a token was valid.
+func (cfg *apiConfig) handlerOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	parseErr := r.ParseForm()
+
+	if parseErr != nil {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "invalid form body")
+		return
+	}
+
+	client, clientErr := cfg.authenticateOAuthClient(r)
+
+	if clientErr != nil {
+		respondWithOAuthError(w, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+		return
+	}
+
+	token := r.PostForm.Get("token")
+
+	if len(token) == 0 {
+		respondWithOAuthError(w, http.StatusBadRequest, "invalid_request", "token is required")
+		return
+	}
+
+	if jwtClaim, claimErr := getJWTClaim(cfg.tokens, token); claimErr == nil {
+		if jwtClaim.ClientId == client.ClientId {
+			cfg.db.RevokeAccessToken(jwtClaim.ID, jwtClaim.ExpiresAt.Time)
+		}
+	} else if deleteErr := cfg.db.DeleteClientSessionByToken(token, client.ClientId); deleteErr != nil {
+		respondWithOAuthError(w, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
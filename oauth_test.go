@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/walrus811/chirpy/internal/database"
+	"github.com/walrus811/chirpy/internal/keyring"
+	"github.com/walrus811/chirpy/internal/ratelimit"
+)
+
+// newTestConfig returns a config backed by a fresh database in a temporary
+// directory.
+func newTestConfig(t *testing.T) *apiConfig {
+	db, dbErr := database.NewDB(filepath.Join(t.TempDir(), "database.json"))
+	if dbErr != nil {
+		t.Fatal(dbErr)
+	}
+
+	keys := keyring.New()
+	keys.AddHMAC("", []byte("test secret"))
+	if activeErr := keys.SetActive(""); activeErr != nil {
+		t.Fatal(activeErr)
+	}
+
+	return &apiConfig{
+		tokens:      &jwtConfig{keys: keys, issuer: jwtIssuer, audience: "chirpy-api", revocations: db},
+		db:          db,
+		refreshTTL:  time.Hour,
+		rateLimits:  loadRateLimits(),
+		rateLimiter: ratelimit.NewLimiter(time.Hour),
+	}
+}
+
+func newOAuthTestServer(t *testing.T) (*apiConfig, *httptest.Server) {
+	cfg := newTestConfig(t)
+
+	mux := http.NewServeMux()
+	cfg.registerOAuthRoutes(mux)
+	mux.Handle("GET /scoped", cfg.middlewareAuth(scopeChirpsWrite, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromRequest(r)
+		w.Write([]byte(strconv.Itoa(caller.UserId)))
+	})))
+	mux.Handle("PUT /api/users", cfg.middlewareAuth(scopeProfileWrite, http.HandlerFunc(cfg.handlerUsersUpdate)))
+	mux.Handle("GET /unscoped", cfg.middlewareAuth(scopeAll, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return cfg, server
+}
+
+func TestOAuthAuthorizationCodeFlow(t *testing.T) {
+	cfg, server := newOAuthTestServer(t)
+	httpClient := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	user, _ := cfg.db.CreateUser("oauth@chirpy.com", "1234")
+	loginToken, _ := getJWTString(cfg.tokens, strconv.Itoa(user.Id))
+	const redirectURI = "https://app.example.com/callback"
+
+	registerReq, _ := http.NewRequest("POST", server.URL+"/api/oauth/clients", strings.NewReader(`{"name":"Example","redirect_uris":["`+redirectURI+`"]}`))
+	registerReq.Header.Set("Authorization", "Bearer "+loginToken)
+	registerRes, registerErr := httpClient.Do(registerReq)
+	if registerErr != nil || registerRes.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected client to be registered, got %v %v", registerRes.StatusCode, registerErr)
+	}
+	client := oauthClientResponse{}
+	json.NewDecoder(registerRes.Body).Decode(&client)
+
+	verifier := strings.Repeat("v", 43)
+	authorizeParams := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {client.ClientId},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"chirps:write chirps:read"},
+		"state":                 {"xyz"},
+		"code_challenge":        {database.PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	pageRes, _ := httpClient.Get(server.URL + "/oauth/authorize?" + authorizeParams.Encode())
+	if pageRes.StatusCode != http.StatusOK || pageRes.Header.Get("X-Frame-Options") != "DENY" {
+		t.Fatalf("Expected consent page, got %v", pageRes.StatusCode)
+	}
+
+	badRedirect := url.Values{"client_id": {client.ClientId}, "redirect_uri": {"https://evil.example.com/"}}
+	if res, _ := httpClient.Get(server.URL + "/oauth/authorize?" + badRedirect.Encode()); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected unregistered redirect URI to be refused, got %v", res.StatusCode)
+	}
+
+	consent := url.Values{"email": {user.Email}, "password": {"1234"}, "decision": {"approve"}}
+	for name, values := range authorizeParams {
+		consent[name] = values
+	}
+
+	consentRes, _ := httpClient.PostForm(server.URL+"/oauth/authorize", consent)
+	if consentRes.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect to client, got %v", consentRes.StatusCode)
+	}
+	location, _ := url.Parse(consentRes.Header.Get("Location"))
+	code := location.Query().Get("code")
+	if !strings.HasPrefix(location.String(), redirectURI) || len(code) == 0 || location.Query().Get("state") != "xyz" {
+		t.Fatalf("Unexpected redirect %v", location)
+	}
+
+	exchange := func(codeVerifier string) *http.Response {
+		res, _ := httpClient.PostForm(server.URL+"/oauth/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"client_id":     {client.ClientId},
+			"code_verifier": {codeVerifier},
+		})
+		return res
+	}
+
+	if res := exchange(strings.Repeat("w", 43)); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected wrong verifier to be refused, got %v", res.StatusCode)
+	}
+
+	tokenRes := exchange(verifier)
+	if tokenRes.StatusCode != http.StatusOK {
+		t.Fatalf("Expected code exchange to succeed, got %v", tokenRes.StatusCode)
+	}
+	tokens := oauthTokenResponse{}
+	json.NewDecoder(tokenRes.Body).Decode(&tokens)
+	if tokens.Scope != "chirps:read chirps:write" || tokens.TokenType != "Bearer" {
+		t.Errorf("Unexpected token response %+v", tokens)
+	}
+
+	get := func(path, token string) int {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, _ := httpClient.Do(req)
+		return res.StatusCode
+	}
+
+	if status := get("/scoped", tokens.AccessToken); status != http.StatusOK {
+		t.Errorf("Expected scoped route to accept the token, got %v", status)
+	}
+	if status := get("/unscoped", tokens.AccessToken); status != http.StatusForbidden {
+		t.Errorf("Expected unscoped route to refuse the token, got %v", status)
+	}
+
+	refreshRes, _ := httpClient.PostForm(server.URL+"/oauth/token", url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {tokens.RefreshToken},
+		"client_id":     {client.ClientId},
+	})
+	if refreshRes.StatusCode != http.StatusOK {
+		t.Fatalf("Expected refresh to succeed, got %v", refreshRes.StatusCode)
+	}
+	refreshed := oauthTokenResponse{}
+	json.NewDecoder(refreshRes.Body).Decode(&refreshed)
+
+	revokeRes, _ := httpClient.PostForm(server.URL+"/oauth/revoke", url.Values{"token": {refreshed.AccessToken}, "client_id": {client.ClientId}})
+	if revokeRes.StatusCode != http.StatusOK {
+		t.Errorf("Expected revocation to succeed, got %v", revokeRes.StatusCode)
+	}
+	if status := get("/scoped", refreshed.AccessToken); status != http.StatusUnauthorized {
+		t.Errorf("Expected revoked token to be refused, got %v", status)
+	}
+
+	// Replaying the code revokes the session it was exchanged for.
+	if res := exchange(verifier); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected code reuse to be refused, got %v", res.StatusCode)
+	}
+	if sessions, _ := cfg.db.GetSessions(user.Id); len(sessions) != 0 {
+		t.Errorf("Expected code reuse to revoke the session, got %d sessions", len(sessions))
+	}
+}
+
+func TestOAuthProfileWriteKeepsCredentials(t *testing.T) {
+	cfg, server := newOAuthTestServer(t)
+
+	user, _ := cfg.db.CreateUser("profile@chirpy.com", "1234")
+	token, _ := getScopedJWTString(cfg.tokens, strconv.Itoa(user.Id), "client", []string{scopeProfileWrite})
+
+	update := func(body string) int {
+		req, _ := http.NewRequest("PUT", server.URL+"/api/users", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, _ := http.DefaultClient.Do(req)
+		return res.StatusCode
+	}
+
+	if status := update(`{"bio":"hello"}`); status != http.StatusOK {
+		t.Errorf("Expected profile:write to edit the profile, got %v", status)
+	}
+	if status := update(`{"password":"stolen"}`); status != http.StatusForbidden {
+		t.Errorf("Expected profile:write to be refused a password change, got %v", status)
+	}
+	if status := update(`{"email":"attacker@example.com"}`); status != http.StatusForbidden {
+		t.Errorf("Expected profile:write to be refused an email change, got %v", status)
+	}
+
+	if updated, _ := cfg.db.GetUser(user.Id); updated.Email != user.Email || updated.Password != user.Password {
+		t.Errorf("Expected credentials to be unchanged, got %v", updated.Email)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type voteRequest struct {
+	Option int `json:"option"`
+}
+
+func (cfg *apiConfig) handlerChirpsVote(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirpID, atoiErr := strconv.Atoi(r.PathValue("chirpID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	reqObj := voteRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	chirp, voteErr := cfg.db.Vote(chirpID, userId, reqObj.Option)
+
+	switch {
+	case voteErr == nil:
+		respondWithJson(w, http.StatusOK, chirp)
+	case errors.Is(voteErr, database.ErrPollClosed), errors.Is(voteErr, database.ErrAlreadyVoted):
+		respondWithError(w, http.StatusConflict, voteErr.Error())
+	case errors.Is(voteErr, database.ErrInvalidVote):
+		respondWithError(w, http.StatusBadRequest, voteErr.Error())
+	default:
+		respondWithError(w, http.StatusNotFound, "not found")
+	}
+}
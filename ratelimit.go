@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/walrus811/chirpy/internal/ratelimit"
+)
+
+// Route groups share one bucket per client, so e.g. every write endpoint
+// draws from the same allowance.
+const (
+	rateLimitDefault = "default"
+	rateLimitAuth    = "auth"
+	rateLimitWrite   = "write"
+)
+
+// loadRateLimits reads RATE_LIMIT_<GROUP>_PER_MINUTE for each group. A value
+// of 0 turns limiting off for that group.
+func loadRateLimits() map[string]ratelimit.Rate {
+	defaults := map[string]int64{
+		rateLimitDefault: 600,
+		rateLimitAuth:    10,
+		rateLimitWrite:   30,
+	}
+
+	rates := map[string]ratelimit.Rate{}
+
+	for group, perMinute := range defaults {
+		name := fmt.Sprintf("RATE_LIMIT_%s_PER_MINUTE", strings.ToUpper(group))
+		rates[group] = ratelimit.Rate{Requests: int(getEnvInt64(name, perMinute)), Per: time.Minute}
+	}
+
+	return rates
+}
+
+// middlewareRateLimit throttles requests in group, keyed by the authenticated
+// user or else the client IP. Chirpy Red users get redRateMultiplier times
+// the group's limit.
+func (cfg *apiConfig) middlewareRateLimit(group string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rate := cfg.rateLimits[group]
+		key := group + ":ip:" + clientIP(r)
+
+		if userId, err := cfg.peekUserId(r); err == nil {
+			key = fmt.Sprintf("%s:user:%d", group, userId)
+
+			if user, userErr := cfg.db.GetUser(userId); userErr == nil && user.IsChirpyRed && cfg.redRateMultiplier > 1 {
+				rate.Requests *= cfg.redRateMultiplier
+			}
+		}
+
+		result := cfg.rateLimiter.Allow(key, rate, time.Now())
+
+		if result.Limit > 0 {
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		}
+
+		if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			respondWithError(w, http.StatusTooManyRequests, "Too many requests")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address of the peer. Forwarding headers are ignored
+// since they can be set by any client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,67 @@
+package main
+
+import "net/http"
+
+// handlerRelationCreate returns a handler that blocks or mutes the user named
+// in the path.
+func (cfg *apiConfig) handlerRelationCreate(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromRequest(r)
+		userId := caller.UserId
+
+		target, getErr := cfg.db.GetUserByUsername(r.PathValue("username"))
+
+		if getErr != nil {
+			respondWithError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		relation, createErr := cfg.db.CreateRelation(userId, target.Id, kind)
+
+		if createErr != nil {
+			respondWithError(w, http.StatusBadRequest, createErr.Error())
+			return
+		}
+
+		respondWithJson(w, http.StatusOK, relation)
+	}
+}
+
+func (cfg *apiConfig) handlerRelationDelete(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromRequest(r)
+		userId := caller.UserId
+
+		target, getErr := cfg.db.GetUserByUsername(r.PathValue("username"))
+
+		if getErr != nil {
+			respondWithError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		deleteErr := cfg.db.DeleteRelation(userId, target.Id, kind)
+
+		if deleteErr != nil {
+			respondWithError(w, http.StatusNotFound, "not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (cfg *apiConfig) handlerRelationList(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		caller, _ := principalFromRequest(r)
+		userId := caller.UserId
+
+		relations, err := cfg.db.GetRelations(userId, kind)
+
+		if err != nil {
+			respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+			return
+		}
+
+		respondWithJson(w, http.StatusOK, relations)
+	}
+}
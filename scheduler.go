@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+// runScheduler publishes due scheduled chirps every interval. The first run
+// happens immediately so chirps that fell due during a restart go out
+// without waiting for the first tick.
+func (cfg *apiConfig) runScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		published, err := cfg.db.PublishDueChirps(time.Now())
+
+		if err != nil {
+			fmt.Println("Error publishing scheduled chirps:", err)
+		} else if len(published) > 0 {
+			fmt.Printf("Published %d scheduled chirps\n", len(published))
+		}
+
+		<-ticker.C
+	}
+}
+
+// runPurger permanently removes chirps that have been in the trash for longer
+// than the retention window, along with expired idempotency keys, access
+// token revocations and OAuth codes, every interval.
+func (cfg *apiConfig) runPurger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := cfg.db.PurgeDeletedChirps(time.Now().Add(-cfg.trashRetention))
+
+		if err != nil {
+			fmt.Println("Error purging deleted chirps:", err)
+		} else if purged > 0 {
+			fmt.Printf("Purged %d deleted chirps\n", purged)
+		}
+
+		if _, keysErr := cfg.db.PurgeExpiredIdempotencyKeys(time.Now()); keysErr != nil {
+			fmt.Println("Error purging idempotency keys:", keysErr)
+		}
+
+		if _, revokedErr := cfg.db.PurgeExpiredRevocations(time.Now()); revokedErr != nil {
+			fmt.Println("Error purging revoked access tokens:", revokedErr)
+		}
+
+		if _, codesErr := cfg.db.PurgeExpiredOAuthCodes(time.Now()); codesErr != nil {
+			fmt.Println("Error purging OAuth codes:", codesErr)
+		}
+
+		<-ticker.C
+	}
+}
+
+func (cfg *apiConfig) handlerChirpsTrash(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirps, err := cfg.db.GetDeletedChirps(userId)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].DeletedAt.After(*chirps[j].DeletedAt)
+	})
+
+	respondWithJson(w, http.StatusOK, chirps)
+}
+
+func (cfg *apiConfig) handlerChirpsRestore(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirpID, atoiErr := strconv.Atoi(r.PathValue("chirpID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	chirp, restoreErr := cfg.db.RestoreChirp(chirpID, userId, cfg.restoreGrace)
+
+	if errors.Is(restoreErr, database.ErrRestoreExpired) {
+		respondWithError(w, http.StatusGone, restoreErr.Error())
+		return
+	}
+
+	if restoreErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, chirp)
+}
+
+func (cfg *apiConfig) handlerScheduledChirpsGet(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirps, err := cfg.db.GetScheduledChirps(userId)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	sort.Slice(chirps, func(i, j int) bool {
+		return chirps[i].PublishAt.Before(*chirps[j].PublishAt)
+	})
+
+	respondWithJson(w, http.StatusOK, chirps)
+}
+
+func (cfg *apiConfig) handlerScheduledChirpsDelete(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+	userId := caller.UserId
+
+	chirpID, atoiErr := strconv.Atoi(r.PathValue("chirpID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid chirp ID")
+		return
+	}
+
+	cancelErr := cfg.db.CancelScheduledChirp(chirpID, userId)
+
+	if cancelErr != nil {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type sessionResponse struct {
+	Id          int       `json:"id"`
+	DeviceLabel string    `json:"device_label"`
+	UserAgent   string    `json:"user_agent"`
+	IP          string    `json:"ip"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastUsedAt  time.Time `json:"last_used_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type getSessionsResponse struct {
+	Sessions []sessionResponse `json:"sessions"`
+}
+
+// sessionInfoFromRequest describes the device logging in. Clients can name
+// the device; otherwise the user agent is used as its label.
+func sessionInfoFromRequest(r *http.Request, deviceLabel string) database.SessionInfo {
+	if len(deviceLabel) == 0 {
+		deviceLabel = r.UserAgent()
+	}
+
+	return database.SessionInfo{
+		DeviceLabel: deviceLabel,
+		UserAgent:   r.UserAgent(),
+		IP:          clientIP(r),
+	}
+}
+
+// logTokenReuse records that a rotated-out refresh token was presented and
+// the session it belonged to was revoked.
+func logTokenReuse(session database.Session) {
+	fmt.Printf("Refresh token reused, session %d of user %d revoked\n", session.Id, session.UserId)
+}
+
+func (cfg *apiConfig) handlerSessionsList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	sessions, err := cfg.db.GetSessions(caller.UserId)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resObj := getSessionsResponse{Sessions: make([]sessionResponse, 0, len(sessions))}
+
+	for _, session := range sessions {
+		resObj.Sessions = append(resObj.Sessions, sessionResponse{
+			Id:          session.Id,
+			DeviceLabel: session.DeviceLabel,
+			UserAgent:   session.UserAgent,
+			IP:          session.IP,
+			CreatedAt:   session.CreatedAt,
+			LastUsedAt:  session.LastUsedAt,
+			ExpiresAt:   session.ExpiresAt,
+		})
+	}
+
+	respondWithJson(w, http.StatusOK, resObj)
+}
+
+func (cfg *apiConfig) handlerSessionsDelete(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	sessionID, atoiErr := strconv.Atoi(r.PathValue("sessionID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid session ID")
+		return
+	}
+
+	deleteErr := cfg.db.DeleteSession(caller.UserId, sessionID)
+
+	if errors.Is(deleteErr, database.ErrSessionNotFound) {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if deleteErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondWithLogin signs user in on a new session and sends its access and
+// refresh tokens.
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User, device string) {
+	token, tokenErr := getJWTString(cfg.tokens, strconv.Itoa(user.Id))
+
+	if tokenErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	session, createSessionErr := cfg.db.CreateSession(user.Id, sessionInfoFromRequest(r, device), cfg.refreshTTL)
+
+	if createSessionErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	recordIdempotentSession(r, session.Id)
+
+	respondWithJson(w, http.StatusOK, loginUserResponse{user.Id, user.Email, user.IsChirpyRed, token, session.Token})
+}
+
+// respondWithReissuedLogin answers a retried login with new tokens for the
+// session the first attempt created.
+func (cfg *apiConfig) respondWithReissuedLogin(w http.ResponseWriter, sessionId int) {
+	session, reissueErr := cfg.db.ReissueSession(sessionId, cfg.refreshTTL)
+
+	if errors.Is(reissueErr, database.ErrSuspended) {
+		respondWithError(w, http.StatusForbidden, "Account suspended")
+		return
+	}
+
+	if errors.Is(reissueErr, database.ErrSessionNotFound) {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if reissueErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	user, getErr := cfg.db.GetUser(session.UserId)
+
+	if getErr != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	token, tokenErr := getJWTString(cfg.tokens, strconv.Itoa(user.Id))
+
+	if tokenErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.Header().Set("Idempotent-Replayed", "true")
+	respondWithJson(w, http.StatusOK, loginUserResponse{user.Id, user.Email, user.IsChirpyRed, token, session.Token})
+}
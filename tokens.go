@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/walrus811/chirpy/internal/database"
+)
+
+type createTokenRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type tokenResponse struct {
+	Id         int        `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Token      string     `json:"token,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+type getTokensResponse struct {
+	Tokens []tokenResponse `json:"tokens"`
+}
+
+func newTokenResponse(pat database.PersonalAccessToken) tokenResponse {
+	return tokenResponse{pat.Id, pat.Name, pat.Scopes, pat.Token, pat.CreatedAt, pat.LastUsedAt, pat.ExpiresAt}
+}
+
+// handlerTokensCreate issues a personal access token. The token is only
+// returned in this response.
+func (cfg *apiConfig) handlerTokensCreate(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	reqObj := createTokenRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	for _, scope := range reqObj.Scopes {
+		if !slices.Contains(knownScopes, scope) {
+			respondWithError(w, http.StatusBadRequest, fmt.Sprintf("unknown scope %q", scope))
+			return
+		}
+	}
+
+	slices.Sort(reqObj.Scopes)
+	scopes := slices.Compact(reqObj.Scopes)
+
+	pat, createErr := cfg.db.CreatePersonalAccessToken(caller.UserId, reqObj.Name, scopes, reqObj.ExpiresAt)
+
+	if errors.Is(createErr, database.ErrInvalidAccessToken) {
+		respondWithError(w, http.StatusBadRequest, createErr.Error())
+		return
+	}
+
+	if createErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusCreated, newTokenResponse(pat))
+}
+
+func (cfg *apiConfig) handlerTokensList(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	pats, err := cfg.db.GetPersonalAccessTokens(caller.UserId)
+
+	if err != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	resObj := getTokensResponse{Tokens: make([]tokenResponse, 0, len(pats))}
+
+	for _, pat := range pats {
+		resObj.Tokens = append(resObj.Tokens, newTokenResponse(pat))
+	}
+
+	respondWithJson(w, http.StatusOK, resObj)
+}
+
+func (cfg *apiConfig) handlerTokensDelete(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	tokenID, atoiErr := strconv.Atoi(r.PathValue("tokenID"))
+
+	if atoiErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	deleteErr := cfg.db.DeletePersonalAccessToken(caller.UserId, tokenID)
+
+	if errors.Is(deleteErr, database.ErrAccessTokenNotFound) {
+		respondWithError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if deleteErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/walrus811/chirpy/internal/database"
+	"github.com/walrus811/chirpy/internal/totp"
+)
+
+const (
+	// loginChallengeAudience keeps login challenges from being accepted as
+	// access tokens, and the other way around.
+	loginChallengeAudience = "chirpy-login-challenge"
+	loginChallengeTTL      = 5 * time.Minute
+	// totpSkew accepts codes one step either side of the current one.
+	totpSkew = 1
+)
+
+var (
+	errInvalidSecondFactor  = errors.New("invalid two-factor code")
+	errTwoFactorUnavailable = errors.New("two-factor authentication is not configured")
+)
+
+// loginChallengeClaims are the claims of the token returned by the first
+// step of a two-factor login. It proves the password was right, and is
+// traded for an access token along with a code.
+type loginChallengeClaims struct {
+	jwt.RegisteredClaims
+	Device string `json:"device,omitempty"`
+}
+
+type loginChallengeResponse struct {
+	TwoFactorRequired bool   `json:"two_factor_required"`
+	ChallengeToken    string `json:"challenge_token"`
+	ExpiresIn         int    `json:"expires_in"`
+}
+
+type loginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type twoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func getLoginChallenge(tokens *jwtConfig, userId int, device string) (string, error) {
+	jti, jtiErr := newTokenId()
+
+	if jtiErr != nil {
+		return "", jtiErr
+	}
+
+	claim := loginChallengeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    tokens.issuer,
+			Audience:  jwt.ClaimStrings{loginChallengeAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
+			Subject:   strconv.Itoa(userId),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(loginChallengeTTL)),
+		},
+		Device: device,
+	}
+	return tokens.keys.Sign(claim)
+}
+
+// parseLoginChallenge validates a login challenge like getJWTClaim does an
+// access token. Challenges are revoked once used.
+func parseLoginChallenge(tokens *jwtConfig, token string) (*loginChallengeClaims, int, error) {
+	claim := &loginChallengeClaims{}
+	_, err := jwt.ParseWithClaims(token, claim, tokens.keys.Keyfunc,
+		jwt.WithValidMethods(tokens.keys.Methods()),
+		jwt.WithIssuer(tokens.issuer),
+		jwt.WithAudience(loginChallengeAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokens.leeway),
+	)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	userId, atoiErr := strconv.Atoi(claim.Subject)
+
+	if atoiErr != nil {
+		return nil, 0, errInvalidToken
+	}
+
+	if tokens.revocations.IsAccessTokenRevoked(claim.ID, userId, claim.IssuedAt.Time) {
+		return nil, 0, errRevokedToken
+	}
+
+	return claim, userId, nil
+}
+
+// totpAdditionalData binds a sealed TOTP secret to its user.
+func totpAdditionalData(userId int) []byte {
+	return []byte("totp:user:" + strconv.Itoa(userId))
+}
+
+func (cfg *apiConfig) openTOTPSecret(user database.User) ([]byte, error) {
+	if cfg.totpSecrets == nil {
+		return nil, errTwoFactorUnavailable
+	}
+
+	return cfg.totpSecrets.Open(user.TOTPSecret, totpAdditionalData(user.Id))
+}
+
+// verifySecondFactor checks a code from the user's authenticator or one of
+// their recovery codes. Each code is accepted once.
+func (cfg *apiConfig) verifySecondFactor(user database.User, code string) error {
+	code = strings.TrimSpace(code)
+
+	if len(code) != totp.Digits {
+		recoveryErr := cfg.db.UseRecoveryCode(user.Id, code)
+
+		if errors.Is(recoveryErr, database.ErrInvalidRecoveryCode) {
+			return errInvalidSecondFactor
+		}
+
+		return recoveryErr
+	}
+
+	secret, openErr := cfg.openTOTPSecret(user)
+
+	if openErr != nil {
+		return openErr
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+
+	if !ok {
+		return errInvalidSecondFactor
+	}
+
+	useErr := cfg.db.UseTOTPStep(user.Id, step)
+
+	if errors.Is(useErr, database.ErrTOTPCodeUsed) {
+		return errInvalidSecondFactor
+	}
+
+	return useErr
+}
+
+// respondSecondFactorError maps an error of verifySecondFactor to a
+// response.
+func respondSecondFactorError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errInvalidSecondFactor) {
+		respondWithError(w, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	if errors.Is(err, errTwoFactorUnavailable) {
+		respondWithError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+}
+
+// handlerLoginTwoFactor completes the login of a user enrolled in
+// two-factor authentication.
+func (cfg *apiConfig) handlerLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	reqObj := loginTwoFactorRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	challenge, userId, challengeErr := parseLoginChallenge(cfg.tokens, reqObj.ChallengeToken)
+
+	if challengeErr != nil {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	user, getErr := cfg.db.GetUser(userId)
+
+	if getErr != nil || !user.TOTPEnabled {
+		respondWithError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	if user.Suspended {
+		respondWithError(w, http.StatusForbidden, "Account suspended")
+		return
+	}
+
+	factorErr := cfg.verifySecondFactor(user, reqObj.Code)
+
+	if factorErr != nil {
+		respondSecondFactorError(w, factorErr)
+		return
+	}
+
+	revokeErr := cfg.db.RevokeAccessToken(challenge.ID, challenge.ExpiresAt.Time)
+
+	if revokeErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user, challenge.Device)
+}
+
+// handlerTwoFactorEnroll generates a new authenticator secret for the
+// caller. It isn't required at login until confirmed with a code.
+func (cfg *apiConfig) handlerTwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	if cfg.totpSecrets == nil {
+		respondWithError(w, http.StatusServiceUnavailable, errTwoFactorUnavailable.Error())
+		return
+	}
+
+	user, getErr := cfg.db.GetUser(caller.UserId)
+
+	if getErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, database.ErrTOTPEnabled.Error())
+		return
+	}
+
+	secret, secretErr := totp.GenerateSecret()
+
+	if secretErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	sealed, sealErr := cfg.totpSecrets.Seal(secret, totpAdditionalData(user.Id))
+
+	if sealErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	setErr := cfg.db.SetTOTPSecret(user.Id, sealed)
+
+	if setErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, twoFactorEnrollResponse{totp.EncodeSecret(secret), totp.KeyURI("Chirpy", user.Email, secret)})
+}
+
+// handlerTwoFactorConfirm turns two-factor authentication on once the
+// caller proves their authenticator works, and returns recovery codes.
+func (cfg *apiConfig) handlerTwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	reqObj := twoFactorCodeRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, getErr := cfg.db.GetUser(caller.UserId)
+
+	if getErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, database.ErrTOTPEnabled.Error())
+		return
+	}
+
+	if len(user.TOTPSecret) == 0 {
+		respondWithError(w, http.StatusConflict, "Enrolment not started")
+		return
+	}
+
+	secret, openErr := cfg.openTOTPSecret(user)
+
+	if openErr != nil {
+		respondSecondFactorError(w, openErr)
+		return
+	}
+
+	step, ok := totp.Validate(secret, strings.TrimSpace(reqObj.Code), time.Now(), totpSkew)
+
+	if !ok {
+		respondSecondFactorError(w, errInvalidSecondFactor)
+		return
+	}
+
+	codes, enableErr := cfg.db.EnableTOTP(user.Id, step)
+
+	if errors.Is(enableErr, database.ErrTOTPEnabled) {
+		respondWithError(w, http.StatusConflict, enableErr.Error())
+		return
+	}
+
+	if enableErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	respondWithJson(w, http.StatusOK, recoveryCodesResponse{codes})
+}
+
+// handlerTwoFactorDisable turns two-factor authentication off. A current
+// code or a recovery code is required, so a stolen access token alone
+// can't do it.
+func (cfg *apiConfig) handlerTwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	caller, _ := principalFromRequest(r)
+
+	reqObj := twoFactorCodeRequest{}
+	decodeErr := json.NewDecoder(r.Body).Decode(&reqObj)
+
+	if decodeErr != nil {
+		respondWithError(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	user, getErr := cfg.db.GetUser(caller.UserId)
+
+	if getErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	if !user.TOTPEnabled {
+		respondWithError(w, http.StatusConflict, database.ErrTOTPNotEnabled.Error())
+		return
+	}
+
+	factorErr := cfg.verifySecondFactor(user, reqObj.Code)
+
+	if factorErr != nil {
+		respondSecondFactorError(w, factorErr)
+		return
+	}
+
+	disableErr := cfg.db.DisableTOTP(user.Id)
+
+	if disableErr != nil {
+		respondWithError(w, http.StatusInternalServerError, "Something went wrong")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}